@@ -2,9 +2,10 @@ package electrum
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
-	"strconv"
 
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 )
 
@@ -18,6 +19,8 @@ func (n *Node) BlockchainNumBlocksSubscribe() (int, error) {
 	return resp.Result, err
 }
 
+// BlockchainHeader is a block header in either the legacy dict format or the
+// raw format used by protocol 1.2+ servers. Raw is only set for the latter.
 type BlockchainHeader struct {
 	Nonce         uint64 `json:"nonce"`
 	PrevBlockHash string `json:"prev_block_hash"`
@@ -27,6 +30,8 @@ type BlockchainHeader struct {
 	UtxoRoot      string `json:"utxo_root"`
 	Version       int    `json:"version"`
 	Bits          uint64 `json:"bits"`
+
+	Raw *RawBlockHeader `json:"-"`
 }
 
 // BlockchainHeadersSubscribe request client notifications about new blocks in
@@ -36,13 +41,17 @@ func (n *Node) BlockchainHeadersSubscribe() (<-chan *BlockchainHeader, error) {
 	resp := &struct {
 		Result *BlockchainHeader `json:"result"`
 	}{}
+	msgs := n.listenPush("blockchain.headers.subscribe")
 	if err := n.request("blockchain.headers.subscribe", nil, resp); err != nil {
+		n.unlistenPush("blockchain.headers.subscribe", msgs)
 		return nil, err
 	}
 	headerChan := make(chan *BlockchainHeader, 1)
 	headerChan <- resp.Result
 	go func() {
-		for msg := range n.listenPush("blockchain.headers.subscribe") {
+		defer close(headerChan)
+		defer n.unlistenPush("blockchain.headers.subscribe", msgs)
+		for msg := range msgs {
 			resp := &struct {
 				Params []*BlockchainHeader `json:"params"`
 			}{}
@@ -51,7 +60,11 @@ func (n *Node) BlockchainHeadersSubscribe() (<-chan *BlockchainHeader, error) {
 				return
 			}
 			for _, param := range resp.Params {
-				headerChan <- param
+				select {
+				case headerChan <- param:
+				case <-n.done:
+					return
+				}
 			}
 		}
 	}()
@@ -62,17 +75,19 @@ func (n *Node) BlockchainHeadersSubscribe() (<-chan *BlockchainHeader, error) {
 // returns the hash of the transaction history.
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-address-subscribe
 func (n *Node) BlockchainAddressSubscribe(address string) (<-chan string, error) {
+	msgs := n.listenPush("blockchain.address.subscribe")
 	resp := &basicResp{}
-	err := n.request("blockchain.address.subscribe", []string{address}, resp)
+	err := n.request("blockchain.address.subscribe", []interface{}{address}, resp)
 	if err != nil {
+		n.unlistenPush("blockchain.address.subscribe", msgs)
 		return nil, err
 	}
 	addressChan := make(chan string, 1)
-	if len(resp.Result) > 0 {
-		addressChan <- resp.Result
-	}
+	addressChan <- resp.Result
 	go func() {
-		for msg := range n.listenPush("blockchain.address.subscribe") {
+		defer close(addressChan)
+		defer n.unlistenPush("blockchain.address.subscribe", msgs)
+		for msg := range msgs {
 			resp := &struct {
 				Params []string `json:"params"`
 			}{}
@@ -84,34 +99,39 @@ func (n *Node) BlockchainAddressSubscribe(address string) (<-chan string, error)
 				log.Printf("address subscription params len != 2 %+v", resp.Params)
 				continue
 			}
-			if resp.Params[0] == address {
-				addressChan <- resp.Params[1]
+			if resp.Params[0] != address {
+				continue
+			}
+			select {
+			case addressChan <- resp.Params[1]:
+			case <-n.done:
+				return
 			}
 		}
 	}()
 	return addressChan, err
 }
 
-type Transaction struct {
-	Hash   string `json:"tx_hash"`
-	Height int    `json:"height"`
-	Value  int    `json:"value"`
-	Pos    int    `json:"tx_pos"`
-}
-
 // BlockchainAddressGetHistory returns the history of an address.
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-address-get-history
-func (n *Node) BlockchainAddressGetHistory(address string) ([]*Transaction, error) {
+func (n *Node) BlockchainAddressGetHistory(address string) ([]*HistoryItem, error) {
 	resp := &struct {
-		Result []*Transaction `json:"result"`
+		Result []*HistoryItem `json:"result"`
 	}{}
-	err := n.request("blockchain.address.get_history", []string{address}, resp)
+	err := n.request("blockchain.address.get_history", []interface{}{address}, resp)
 	return resp.Result, err
 }
 
-// TODO(d4l3k) implement
+// BlockchainAddressGetMempool returns the unconfirmed transactions of an
+// address along with their fees.
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-address-get-mempool
-func (n *Node) BlockchainAddressGetMempool() error { return ErrNotImplemented }
+func (n *Node) BlockchainAddressGetMempool(address string) ([]*HistoryItem, error) {
+	resp := &struct {
+		Result []*HistoryItem `json:"result"`
+	}{}
+	err := n.request("blockchain.address.get_mempool", []interface{}{address}, resp)
+	return resp.Result, err
+}
 
 type Balance struct {
 	Confirmed   btcutil.Amount `json:"confirmed"`
@@ -125,7 +145,7 @@ func (n *Node) BlockchainAddressGetBalance(address string) (*Balance, error) {
 	resp := &struct {
 		Result *Balance `json:"result"`
 	}{}
-	err := n.request("blockchain.address.get_balance", []string{address}, resp)
+	err := n.request("blockchain.address.get_balance", []interface{}{address}, resp)
 	return resp.Result, err
 }
 
@@ -135,11 +155,11 @@ func (n *Node) BlockchainAddressGetProof() error { return ErrNotImplemented }
 
 // BlockchainAddressListUnspent lists the unspent transactions for the given address.
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-address-listunspent
-func (n *Node) BlockchainAddressListUnspent(address string) ([]*Transaction, error) {
+func (n *Node) BlockchainAddressListUnspent(address string) ([]*UTXO, error) {
 	resp := &struct {
-		Result []*Transaction `json:"result"`
+		Result []*UTXO `json:"result"`
 	}{}
-	err := n.request("blockchain.address.listunspent", []string{address}, resp)
+	err := n.request("blockchain.address.listunspent", []interface{}{address}, resp)
 	return resp.Result, err
 }
 
@@ -147,35 +167,134 @@ func (n *Node) BlockchainAddressListUnspent(address string) ([]*Transaction, err
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-utxo-get-address
 func (n *Node) BlockchainUtxoGetAddress() error { return ErrNotImplemented }
 
-// TODO(d4l3k) implement
+// BlockchainBlockHeader returns the raw block header at the given height.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-block-header
+func (n *Node) BlockchainBlockHeader(height uint64) (*BlockchainHeader, error) {
+	key := fmt.Sprintf("header:%d", height)
+	header := &BlockchainHeader{}
+	if n.cacheGet(key, header) {
+		return header, nil
+	}
+	resp := &basicResp{}
+	if err := n.request("blockchain.block.header", []interface{}{height}, resp); err != nil {
+		return nil, err
+	}
+	raw, err := ParseRawBlockHeader(resp.Result, height)
+	if err != nil {
+		return nil, err
+	}
+	header = raw.BlockchainHeader()
+	n.cachePutFinal(key, header, height)
+	return header, nil
+}
+
+// BlockchainBlockHeaders returns up to count consecutive block headers
+// starting at height start.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-block-headers
+func (n *Node) BlockchainBlockHeaders(start uint64, count uint) ([]*BlockchainHeader, error) {
+	resp := &struct {
+		Result struct {
+			Count uint   `json:"count"`
+			Hex   string `json:"hex"`
+		} `json:"result"`
+	}{}
+	if err := n.request("blockchain.block.headers", []interface{}{start, count}, resp); err != nil {
+		return nil, err
+	}
+	const size = 2 * wire.MaxBlockHeaderPayload
+	if resp.Result.Count > count || len(resp.Result.Hex) != int(resp.Result.Count)*size {
+		return nil, fmt.Errorf("server returned %d bytes for %d headers", len(resp.Result.Hex)/2, resp.Result.Count)
+	}
+	headers := make([]*BlockchainHeader, resp.Result.Count)
+	for i := range headers {
+		raw, err := ParseRawBlockHeader(resp.Result.Hex[i*size:(i+1)*size], start+uint64(i))
+		if err != nil {
+			return nil, err
+		}
+		headers[i] = raw.BlockchainHeader()
+	}
+	return headers, nil
+}
+
+// BlockchainBlockGetHeader returns the block header at the given height.
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-block-get-header
-func (n *Node) BlockchainBlockGetHeader() error { return ErrNotImplemented }
+func (n *Node) BlockchainBlockGetHeader(height uint64) (*BlockchainHeader, error) {
+	key := fmt.Sprintf("header:%d", height)
+	header := &BlockchainHeader{}
+	if n.cacheGet(key, header) {
+		return header, nil
+	}
+	resp := &struct {
+		Result *BlockchainHeader `json:"result"`
+	}{}
+	if err := n.request("blockchain.block.get_header", []interface{}{height}, resp); err != nil {
+		return nil, err
+	}
+	if resp.Result != nil {
+		n.cachePutFinal(key, resp.Result, height)
+	}
+	return resp.Result, nil
+}
 
 // TODO(d4l3k) implement
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-block-get-chunk
 func (n *Node) BlockchainBlockGetChunk() error { return ErrNotImplemented }
 
-// BlockchainTransactionBroadcast sends a raw transaction.
-// TODO(d4l3k) implement
-// http://docs.electrum.org/en/latest/protocol.html#blockchain-transaction-broadcast
-func (n *Node) BlockchainTransactionBroadcast(tx []byte) (interface{}, error) {
-	resp := &struct {
-		Result interface{} `json:"result"`
-	}{}
-	err := n.request("blockchain.transaction.broadcast", []string{string(tx)}, resp)
-	return resp.Result, err
+// MerkleProof is the merkle branch of a transaction in a block.
+type MerkleProof struct {
+	BlockHeight uint64   `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         int      `json:"pos"`
 }
 
-// TODO(d4l3k) implement
+// BlockchainTransactionGetMerkle returns the merkle branch of a confirmed
+// transaction at the given height.
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-transaction-get-merkle
-func (n *Node) BlockchainTransactionGetMerkle() error { return ErrNotImplemented }
+func (n *Node) BlockchainTransactionGetMerkle(txid string, height uint64) (*MerkleProof, error) {
+	key := fmt.Sprintf("merkle:%s:%d", txid, height)
+	proof := &MerkleProof{}
+	if n.cacheGet(key, proof) {
+		return proof, nil
+	}
+	resp := &struct {
+		Result *MerkleProof `json:"result"`
+	}{}
+	if err := n.request("blockchain.transaction.get_merkle", []interface{}{txid, height}, resp); err != nil {
+		return nil, err
+	}
+	if resp.Result == nil {
+		return nil, ErrEmptyResult
+	}
+	n.cachePutFinal(key, resp.Result, height)
+	return resp.Result, nil
+}
 
 // BlockchainTransactionGet returns the raw transaction (hex-encoded) for the given txid. If transaction doesn't exist, an error is returned.
+// Transactions are verified against txid.
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-transaction-get
 func (n *Node) BlockchainTransactionGet(txid string) (string, error) {
+	return n.BlockchainTransactionGetConfirmed(txid, 0)
+}
+
+// BlockchainTransactionGetConfirmed is BlockchainTransactionGet for a
+// transaction known to be confirmed at height, or 0 if it may be unconfirmed.
+// Transactions are cached by txid; the height only drops them from the cache
+// if that block is reorganized.
+func (n *Node) BlockchainTransactionGetConfirmed(txid string, height int) (string, error) {
+	key := "tx:" + txid
+	var tx string
+	if n.cacheGet(key, &tx) {
+		return tx, nil
+	}
 	resp := &basicResp{}
-	err := n.request("blockchain.transaction.get", []string{txid}, resp)
-	return resp.Result, err
+	if err := n.request("blockchain.transaction.get", []interface{}{txid}, resp); err != nil {
+		return "", err
+	}
+	if err := checkTxID(txid, resp.Result); err != nil {
+		return "", err
+	}
+	n.cachePut(key, resp.Result, uint64(height))
+	return resp.Result, nil
 }
 
 // http://docs.electrum.org/en/latest/protocol.html#blockchain-estimatefee
@@ -184,6 +303,19 @@ func (n *Node) BlockchainEstimateFee(block int) (float64, error) {
 	resp := &struct {
 		Result float64 `json:"result"`
 	}{}
-	err := n.request("blockchain.estimatefee", []string{strconv.Itoa(block)}, resp)
+	err := n.request("blockchain.estimatefee", []interface{}{block}, resp)
 	return resp.Result, err
 }
+
+// BlockchainRelayFee returns the minimum fee per kilobyte a transaction must
+// pay to be accepted into the server's mempool.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-relayfee
+func (n *Node) BlockchainRelayFee() (btcutil.Amount, error) {
+	resp := &struct {
+		Result float64 `json:"result"`
+	}{}
+	if err := n.request("blockchain.relayfee", nil, resp); err != nil {
+		return 0, err
+	}
+	return btcutil.NewAmount(resp.Result)
+}
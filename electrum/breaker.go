@@ -0,0 +1,103 @@
+package electrum
+
+import "time"
+
+// Circuit breaker defaults.
+const (
+	// DefaultBreakerThreshold is the number of consecutive failures after
+	// which a server's circuit opens.
+	DefaultBreakerThreshold = 3
+	// DefaultBreakerCooldown is how long an open circuit stops requests to a
+	// server before letting a trial request through.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// BreakerState is the state of a server's circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen stops requests until the cool-down is over.
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial request through after the
+	// cool-down, failing others fast until it returns. A success closes the
+	// circuit, a failure opens it again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker counts the consecutive transport failures of a server.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	// trial is set while the trial request of a half-open circuit runs.
+	trial bool
+}
+
+func (b *breaker) state(now time.Time) BreakerState {
+	switch {
+	case b.openUntil.IsZero():
+		return BreakerClosed
+	case now.Before(b.openUntil):
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// available returns whether a request could be sent now: the circuit is
+// closed, or half-open without a running trial.
+func (b *breaker) available(now time.Time) bool {
+	switch b.state(now) {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		return !b.trial
+	}
+	return false
+}
+
+// allow returns whether a request may be sent, making it the trial of a
+// half-open circuit.
+func (b *breaker) allow(now time.Time) bool {
+	if !b.available(now) {
+		return false
+	}
+	if b.state(now) == BreakerHalfOpen {
+		b.trial = true
+	}
+	return true
+}
+
+// done ends a request without a transport outcome, such as a server error
+// or a cancelled context, letting another trial through.
+func (b *breaker) done() {
+	b.trial = false
+}
+
+func (b *breaker) success() {
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.trial = false
+}
+
+// failure records a failure, opening the circuit for cooldown once threshold
+// failures happened in a row or a half-open trial failed.
+func (b *breaker) failure(now time.Time, threshold int, cooldown time.Duration) {
+	b.failures++
+	b.trial = false
+	if b.failures >= threshold || !b.openUntil.IsZero() {
+		b.openUntil = now.Add(cooldown)
+	}
+}
@@ -0,0 +1,130 @@
+package electrum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Reasons a broadcast transaction is rejected, see BroadcastError.
+var (
+	ErrFeeTooLow         = errors.New("fee too low")
+	ErrFeeTooHigh        = errors.New("fee too high")
+	ErrMissingInputs     = errors.New("missing inputs")
+	ErrConflict          = errors.New("conflicts with another transaction")
+	ErrNonStandard       = errors.New("non-standard transaction")
+	ErrAlreadyInChain    = errors.New("transaction already in block chain")
+	ErrAlreadyInMempool  = errors.New("transaction already in mempool")
+	ErrBroadcastRejected = errors.New("transaction rejected")
+)
+
+// broadcastReasons maps bitcoind reject reasons to reasons. Codes must match
+// a whole reject reason, messages may appear anywhere in it. Earlier entries
+// take precedence.
+var broadcastReasons = []struct {
+	reason   error
+	codes    []string
+	messages []string
+}{
+	{ErrAlreadyInChain, []string{"txn-already-confirmed"}, []string{"already in block chain"}},
+	{ErrAlreadyInMempool, []string{"txn-already-in-mempool", "txn-already-known"}, []string{"already have transaction"}},
+	{ErrConflict, []string{"txn-mempool-conflict", "bad-txns-spends-conflicting-tx"}, []string{"double spend"}},
+	{ErrFeeTooHigh, []string{"absurdly-high-fee", "max-fee-exceeded"}, []string{"fee exceeds maximum"}},
+	{ErrFeeTooLow, []string{"min-fee-not-met", "insufficient fee"}, []string{"min relay fee not met", "mempool min fee not met", "fee too low"}},
+	{ErrMissingInputs, []string{"missing-inputs", "bad-txns-inputs-missingorspent"}, []string{"missing inputs"}},
+	{ErrNonStandard, []string{"dust", "version", "bad-txns-version", "tx-size", "scriptsig-size", "scriptsig-not-pushonly", "bare-multisig", "multi-op-return", "scriptpubkey"}, []string{"non-standard", "nonstandard"}},
+}
+
+var (
+	rejectCodePrefix = regexp.MustCompile(`^\d+: `)
+	rejectCodeSuffix = regexp.MustCompile(` \(code -?\d+\)$`)
+)
+
+// rejectReasons extracts the reject reasons from a server message, one per
+// line, without the numeric reject code and the details after a comma.
+func rejectReasons(msg string) []string {
+	var reasons []string
+	for _, line := range strings.Split(strings.ToLower(msg), "\n") {
+		line = strings.TrimSpace(line)
+		line = rejectCodePrefix.ReplaceAllString(line, "")
+		line = rejectCodeSuffix.ReplaceAllString(line, "")
+		if i := strings.Index(line, ", "); i >= 0 {
+			line = line[:i]
+		}
+		if line != "" {
+			reasons = append(reasons, line)
+		}
+	}
+	return reasons
+}
+
+// BroadcastError is returned when the server rejects a transaction. Reason is
+// one of the Err* reasons above.
+type BroadcastError struct {
+	Reason  error
+	Message string
+}
+
+func (e *BroadcastError) Error() string {
+	return fmt.Sprintf("broadcast rejected: %s: %s", e.Reason, e.Message)
+}
+
+// Unwrap returns the reason so errors.Is can be used to classify rejections.
+func (e *BroadcastError) Unwrap() error {
+	return e.Reason
+}
+
+// classifyBroadcastError turns a server reject message into a BroadcastError.
+func classifyBroadcastError(msg string) *BroadcastError {
+	reasons := rejectReasons(msg)
+	for _, r := range broadcastReasons {
+		for _, reason := range reasons {
+			for _, c := range r.codes {
+				if reason == c {
+					return &BroadcastError{Reason: r.reason, Message: msg}
+				}
+			}
+			for _, m := range r.messages {
+				if strings.Contains(reason, m) {
+					return &BroadcastError{Reason: r.reason, Message: msg}
+				}
+			}
+		}
+	}
+	return &BroadcastError{Reason: ErrBroadcastRejected, Message: msg}
+}
+
+// BlockchainTransactionBroadcast sends a transaction and returns its txid.
+// Rejections are returned as a *BroadcastError.
+// http://docs.electrum.org/en/latest/protocol.html#blockchain-transaction-broadcast
+func (n *Node) BlockchainTransactionBroadcast(tx *wire.MsgTx) (chainhash.Hash, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return chainhash.Hash{}, err
+	}
+	txid := tx.TxHash()
+
+	resp := &basicResp{}
+	err := n.request("blockchain.transaction.broadcast", []interface{}{hex.EncodeToString(buf.Bytes())}, resp)
+	if serr, ok := err.(*ServerError); ok {
+		return chainhash.Hash{}, classifyBroadcastError(serr.Message)
+	} else if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	// Protocol 1.0 servers return reject messages as the result.
+	hash, err := chainhash.NewHashFromStr(resp.Result)
+	if err != nil || len(resp.Result) != chainhash.MaxHashStringSize {
+		return chainhash.Hash{}, classifyBroadcastError(resp.Result)
+	}
+	if *hash != txid {
+		return chainhash.Hash{}, fmt.Errorf("server returned txid %s, expected %s", hash, txid)
+	}
+	return txid, nil
+}
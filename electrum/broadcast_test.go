@@ -0,0 +1,69 @@
+package electrum
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestClassifyBroadcastError(t *testing.T) {
+	cases := map[string]error{
+		"the transaction was rejected by network rules.\n\nmin relay fee not met, 100 < 141": ErrFeeTooLow,
+		"bad-txns-inputs-missingorspent":     ErrMissingInputs,
+		"txn-mempool-conflict (code 18)":     ErrConflict,
+		"Transaction already in block chain": ErrAlreadyInChain,
+		"txn-already-in-mempool":             ErrAlreadyInMempool,
+		"dust":                               ErrNonStandard,
+		"something unexpected happened":      ErrBroadcastRejected,
+		"64: non-mandatory-script-verify-flag (Signature must be zero)": ErrBroadcastRejected,
+		"absurdly-high-fee, 100000000 > 10000000 (code 256)":            ErrFeeTooHigh,
+		"64: version":                  ErrNonStandard,
+		"bad-txns-version":             ErrNonStandard,
+		"unsupported protocol version": ErrBroadcastRejected,
+		"conflict resolution failed":   ErrBroadcastRejected,
+		"the transaction was rejected by network rules.\n\ntxn-mempool-conflict (code 18)\n[0200]": ErrConflict,
+	}
+	for msg, want := range cases {
+		if err := classifyBroadcastError(msg); !errors.Is(err, want) {
+			t.Errorf("classifyBroadcastError(%q) = %v; want %v", msg, err.Reason, want)
+		}
+	}
+}
+
+func TestBlockchainTransactionBroadcast(t *testing.T) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+
+	n, ft := newFakeNode()
+	result := tx.TxHash().String()
+	ft.handle("blockchain.transaction.broadcast", func([]interface{}) (interface{}, string) {
+		return result, ""
+	})
+	txid, err := n.BlockchainTransactionBroadcast(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txid != tx.TxHash() {
+		t.Errorf("txid = %s", txid)
+	}
+
+	result = chainhash.Hash{2}.String()
+	if _, err := n.BlockchainTransactionBroadcast(tx); err == nil {
+		t.Error("expected txid mismatch error")
+	}
+
+	result = "mempool min fee not met"
+	if _, err := n.BlockchainTransactionBroadcast(tx); !errors.Is(err, ErrFeeTooLow) {
+		t.Errorf("expected ErrFeeTooLow, got %v", err)
+	}
+
+	ft.handle("blockchain.transaction.broadcast", func([]interface{}) (interface{}, string) {
+		return nil, "txn-mempool-conflict"
+	})
+	if _, err := n.BlockchainTransactionBroadcast(tx); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+}
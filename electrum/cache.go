@@ -0,0 +1,291 @@
+package electrum
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CacheEntry is a cached value, optionally tied to the block at Height.
+type CacheEntry struct {
+	Value     []byte
+	Height    uint64
+	HasHeight bool
+}
+
+// CacheBackend stores cache entries beyond the in-memory LRU, e.g. on disk.
+type CacheBackend interface {
+	Get(key string) (*CacheEntry, bool)
+	Put(key string, entry *CacheEntry) error
+	Delete(key string) error
+	// DeleteFrom removes the entries tied to a height at or above height.
+	DeleteFrom(height uint64) error
+}
+
+// CacheStats are the counters of a Cache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+type cacheEntry struct {
+	key string
+	CacheEntry
+}
+
+// Cache is an LRU cache for immutable server responses such as
+// transactions, deep headers and merkle proofs. Entries tied to a block
+// height are dropped by InvalidateFrom when a reorg happens.
+type Cache struct {
+	maxBytes int64
+	backend  CacheBackend
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	stats CacheStats
+}
+
+// NewCache creates a cache holding up to maxBytes of values in memory. The
+// backend is optional and is consulted on misses.
+func NewCache(maxBytes int64, backend CacheBackend) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		backend:  backend,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored for key.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		c.stats.Hits++
+		return e.Value.(*cacheEntry).Value, true
+	}
+	if c.backend != nil {
+		if entry, ok := c.backend.Get(key); ok {
+			c.stats.Hits++
+			c.add(&cacheEntry{key: key, CacheEntry: *entry})
+			return entry.Value, true
+		}
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+// Put stores a value which never changes.
+func (c *Cache) Put(key string, value []byte) {
+	c.put(&cacheEntry{key: key, CacheEntry: CacheEntry{Value: value}})
+}
+
+// PutAtHeight stores a value which is only valid as long as the block at
+// height isn't reorganized.
+func (c *Cache) PutAtHeight(key string, value []byte, height uint64) {
+	c.put(&cacheEntry{key: key, CacheEntry: CacheEntry{Value: value, Height: height, HasHeight: true}})
+}
+
+func (c *Cache) put(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(entry)
+	if c.backend != nil {
+		c.backend.Put(entry.key, &entry.CacheEntry)
+	}
+}
+
+// add inserts an entry into the LRU and evicts old entries. c.mu must be held.
+func (c *Cache) add(entry *cacheEntry) {
+	if e, ok := c.items[entry.key]; ok {
+		c.removeElement(e)
+	}
+	c.items[entry.key] = c.ll.PushFront(entry)
+	c.stats.Entries++
+	c.stats.Bytes += int64(len(entry.Value))
+	for c.stats.Bytes > c.maxBytes && c.ll.Len() > 0 {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+// removeElement removes an entry from the LRU. c.mu must be held.
+func (c *Cache) removeElement(e *list.Element) {
+	entry := c.ll.Remove(e).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.stats.Entries--
+	c.stats.Bytes -= int64(len(entry.Value))
+}
+
+// InvalidateFrom removes all entries at or above height from the cache and
+// its backend.
+func (c *Cache) InvalidateFrom(height uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.ll.Front(); e != nil; {
+		next := e.Next()
+		if entry := e.Value.(*cacheEntry); entry.HasHeight && entry.Height >= height {
+			c.removeElement(e)
+		}
+		e = next
+	}
+	if c.backend != nil {
+		c.backend.DeleteFrom(height)
+	}
+}
+
+// Stats returns the cache counters.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// DiskCache is a CacheBackend storing one file per entry in a directory. Files
+// are named by the hash of their key and start with the entry's height.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache creates a disk backed cache in dir.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+// diskCacheHeaderSize is the size of the header of a cache file: a byte
+// telling whether the entry has a height, followed by the height.
+const diskCacheHeaderSize = 9
+
+func (d *DiskCache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(hash[:]))
+}
+
+func (d *DiskCache) Get(key string) (*CacheEntry, bool) {
+	b, err := ioutil.ReadFile(d.path(key))
+	if err != nil || len(b) < diskCacheHeaderSize {
+		return nil, false
+	}
+	return &CacheEntry{
+		Value:     b[diskCacheHeaderSize:],
+		Height:    binary.BigEndian.Uint64(b[1:diskCacheHeaderSize]),
+		HasHeight: b[0] == 1,
+	}, true
+}
+
+func (d *DiskCache) Put(key string, entry *CacheEntry) error {
+	b := make([]byte, diskCacheHeaderSize, diskCacheHeaderSize+len(entry.Value))
+	if entry.HasHeight {
+		b[0] = 1
+	}
+	binary.BigEndian.PutUint64(b[1:], entry.Height)
+	b = append(b, entry.Value...)
+
+	tmp := d.path(key) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path(key))
+}
+
+// DeleteFrom reads the header of every file, removing the entries at or
+// above height.
+func (d *DiskCache) DeleteFrom(height uint64) error {
+	files, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasSuffix(fi.Name(), ".tmp") {
+			continue
+		}
+		path := filepath.Join(d.dir, fi.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		header := make([]byte, diskCacheHeaderSize)
+		_, err = io.ReadFull(f, header)
+		f.Close()
+		if err != nil || header[0] == 1 && binary.BigEndian.Uint64(header[1:]) >= height {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DiskCache) Delete(key string) error {
+	err := os.Remove(d.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// cacheGet decodes the cached value for key into v.
+func (n *Node) cacheGet(key string, v interface{}) bool {
+	if n.Cache == nil {
+		return false
+	}
+	value, ok := n.Cache.Get(key)
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(value, v) == nil
+}
+
+// cachePutFinal caches v if the block at height is buried deep enough that it
+// won't be reorganized.
+func (n *Node) cachePutFinal(key string, v interface{}, height uint64) {
+	if n.Headers == nil || !n.Headers.IsFinal(height) {
+		return
+	}
+	n.cachePut(key, v, height)
+}
+
+// cachePut caches v, tied to the block at height unless it's 0.
+func (n *Node) cachePut(key string, v interface{}, height uint64) {
+	if n.Cache == nil {
+		return
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if height > 0 {
+		n.Cache.PutAtHeight(key, value, height)
+	} else {
+		n.Cache.Put(key, value)
+	}
+}
+
+// checkTxID verifies that the hex encoded transaction hashes to txid.
+func checkTxID(txid, txHex string) error {
+	tx, err := decodeTx(txHex)
+	if err != nil {
+		return err
+	}
+	if hash := tx.TxHash(); hash.String() != txid {
+		return fmt.Errorf("transaction hashes to %s, expected %s", hash, txid)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package electrum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheEviction(t *testing.T) {
+	c := NewCache(10, nil)
+	c.Put("a", []byte("12345"))
+	c.Put("b", []byte("12345"))
+	c.Get("a")
+	c.Put("c", []byte("12345"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected a to be cached")
+	}
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	c.PutAtHeight("d", []byte("1234567890"), 5)
+	c.InvalidateFrom(5)
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("stats after invalidation = %+v", stats)
+	}
+}
+
+func TestCacheInvalidateFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "electrum-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	disk, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(1024, disk)
+	c.PutAtHeight("header:10", []byte("10"), 10)
+	c.PutAtHeight("header:11", []byte("11"), 11)
+	c.Put("tx:aa", []byte("aa"))
+	c.InvalidateFrom(11)
+	if _, ok := c.Get("header:11"); ok {
+		t.Error("expected header:11 to be invalidated")
+	}
+	if _, ok := disk.Get("header:11"); ok {
+		t.Error("expected header:11 to be removed from disk")
+	}
+
+	// A fresh cache falls back to the disk backend and still knows the
+	// heights of its entries.
+	c = NewCache(1024, disk)
+	for _, key := range []string{"header:10", "tx:aa"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected %s on disk", key)
+		}
+	}
+	c = NewCache(1024, disk)
+	c.InvalidateFrom(10)
+	if _, ok := c.Get("header:10"); ok {
+		t.Error("expected header:10 to be invalidated on disk")
+	}
+	if _, ok := c.Get("tx:aa"); !ok {
+		t.Error("expected tx:aa to survive")
+	}
+
+	// Keys never escape the directory.
+	if err := disk.Put("tx:../../escape", &CacheEntry{Value: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(disk.path("tx:../../escape")) != dir {
+		t.Errorf("path %s outside %s", disk.path("tx:../../escape"), dir)
+	}
+}
+
+func TestNodeCacheVerifiesTransactions(t *testing.T) {
+	// Coinbase transaction of the genesis block.
+	const (
+		txid  = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+		txHex = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+	)
+	n, ft := newFakeNode()
+	n.Cache = NewCache(1<<20, nil)
+	n.Headers = NewHeaderStore(10)
+	n.Headers.Put(&BlockchainHeader{BlockHeight: 100})
+	calls := 0
+	ft.handle("blockchain.transaction.get", func(params []interface{}) (interface{}, string) {
+		calls++
+		return txHex, ""
+	})
+	// Transactions are cached by txid, confirmed or not.
+	for i := 0; i < 2; i++ {
+		if tx, err := n.BlockchainTransactionGet(txid); err != nil || tx != txHex {
+			t.Fatalf("tx = %s, %v", tx, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 server call, got %d", calls)
+	}
+
+	// Transactions confirmed in a reorganized block are fetched again.
+	n.Cache = NewCache(1<<20, nil)
+	if _, err := n.BlockchainTransactionGetConfirmed(txid, 99); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.BlockchainTransactionGet(txid); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 server calls, got %d", calls)
+	}
+	n.Cache.InvalidateFrom(99)
+	if _, err := n.BlockchainTransactionGet(txid); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 server calls after the reorg, got %d", calls)
+	}
+
+	// Transactions are verified with or without a cache.
+	n.Cache = nil
+	if _, err := n.BlockchainTransactionGet("00" + txid[2:]); err == nil {
+		t.Error("expected txid mismatch error")
+	}
+}
@@ -0,0 +1,270 @@
+package electrum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/bech32"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Network describes a chain served by Electrum protocol servers.
+type Network struct {
+	// Params are the chain parameters used for address encoding and header
+	// validation.
+	Params *chaincfg.Params
+
+	// DefaultTCPPort and DefaultSSLPort are the ports servers on this network
+	// listen on unless they advertise otherwise.
+	DefaultTCPPort string
+	DefaultSSLPort string
+
+	// Bootstrap lists well known servers as "host [v1.4] [t[port]] [s[port]]"
+	// descriptors, used by BootstrapDiscoverer. Networks without well known
+	// servers leave it empty and need servers passed explicitly, e.g. with a
+	// StaticDiscoverer.
+	Bootstrap []string
+
+	// PowHash computes the hash compared against the header target. If nil
+	// the block hash is used.
+	PowHash func(header *wire.BlockHeader) (chainhash.Hash, error)
+}
+
+var (
+	MainNet = &Network{
+		Params:         &chaincfg.MainNetParams,
+		DefaultTCPPort: "50001",
+		DefaultSSLPort: "50002",
+		Bootstrap: []string{
+			"electrum.blockstream.info t s",
+			"bitcoin.lukechilds.co t s",
+			"electrum.emzy.de s",
+			"electrum.bitaroo.net s",
+			"fortress.qtornado.com s443",
+		},
+	}
+	TestNet3 = &Network{
+		Params:         &chaincfg.TestNet3Params,
+		DefaultTCPPort: "51001",
+		DefaultSSLPort: "51002",
+		Bootstrap: []string{
+			"electrum.blockstream.info t60001 s60002",
+			"testnet.aranguren.org t s",
+		},
+	}
+	// TestNet4, SigNet and RegTest use the testnet ports Electrum defaults to
+	// for them and have no well known servers.
+	TestNet4 = &Network{
+		Params:         &testNet4Params,
+		DefaultTCPPort: "51001",
+		DefaultSSLPort: "51002",
+	}
+	SigNet = &Network{
+		Params:         &chaincfg.SigNetParams,
+		DefaultTCPPort: "51001",
+		DefaultSSLPort: "51002",
+	}
+	RegTest = &Network{
+		Params:         &chaincfg.RegressionNetParams,
+		DefaultTCPPort: "51001",
+		DefaultSSLPort: "51002",
+	}
+	LitecoinMainNet = &Network{
+		Params:         &litecoinMainNetParams,
+		DefaultTCPPort: "50001",
+		DefaultSSLPort: "50002",
+		Bootstrap: []string{
+			"electrum-ltc.bysh.me t s",
+			"backup.electrum-ltc.org t s443",
+		},
+		PowHash: scryptPowHash,
+	}
+)
+
+var (
+	networksLock sync.RWMutex
+	networks     []*Network
+)
+
+func init() {
+	for _, net := range []*Network{MainNet, TestNet3, TestNet4, SigNet, RegTest, LitecoinMainNet} {
+		if err := RegisterNetwork(net); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterNetwork makes a network known to NetworkByGenesis. The params
+// aren't registered with chaincfg, leaving its global registry to the
+// application.
+func RegisterNetwork(net *Network) error {
+	if net.Params == nil || net.Params.GenesisHash == nil {
+		return errors.New("network params must include a genesis hash")
+	}
+	networksLock.Lock()
+	defer networksLock.Unlock()
+	for _, other := range networks {
+		if other.Params.Name == net.Params.Name {
+			return fmt.Errorf("network %q already registered", net.Params.Name)
+		}
+	}
+	networks = append(networks, net)
+	return nil
+}
+
+// NetworkByGenesis returns the registered network with the given genesis
+// block hash.
+func NetworkByGenesis(genesis string) (*Network, error) {
+	networksLock.RLock()
+	defer networksLock.RUnlock()
+	for _, net := range networks {
+		if net.Params.GenesisHash.String() == genesis {
+			return net, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network with genesis %s", genesis)
+}
+
+// Name returns the name of the network.
+func (net *Network) Name() string {
+	return net.Params.Name
+}
+
+// DecodeAddress decodes an address and ensures it belongs to the network.
+func (net *Network) DecodeAddress(address string) (btcutil.Address, error) {
+	hrp := net.Params.Bech32HRPSegwit
+	if len(hrp) > 0 && strings.HasPrefix(strings.ToLower(address), hrp+"1") &&
+		!chaincfg.IsBech32SegwitPrefix(hrp+"1") {
+		return net.decodeSegWitAddress(address)
+	}
+	addr, err := btcutil.DecodeAddress(address, net.Params)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IsForNet(net.Params) {
+		return nil, fmt.Errorf("address %s is not for %s", address, net.Name())
+	}
+	return addr, nil
+}
+
+// decodeSegWitAddress decodes a segwit address of a network unknown to
+// chaincfg, which btcutil only decodes for registered networks.
+func (net *Network) decodeSegWitAddress(address string) (btcutil.Address, error) {
+	_, data, err := bech32.Decode(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1 {
+		return nil, fmt.Errorf("address %s has no witness program", address)
+	}
+	if data[0] != 0 {
+		return nil, btcutil.UnsupportedWitnessVerError(data[0])
+	}
+	program, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, err
+	}
+	switch len(program) {
+	case 20:
+		return btcutil.NewAddressWitnessPubKeyHash(program, net.Params)
+	case 32:
+		return btcutil.NewAddressWitnessScriptHash(program, net.Params)
+	}
+	return nil, btcutil.UnsupportedWitnessProgLenError(len(program))
+}
+
+// CheckHeader validates the proof of work of a header against the network's
+// proof of work limit.
+func (net *Network) CheckHeader(h *BlockchainHeader) error {
+	header, err := h.WireHeader()
+	if err != nil {
+		return err
+	}
+	target := blockchain.CompactToBig(header.Bits)
+	if target.Sign() <= 0 || target.Cmp(net.Params.PowLimit) > 0 {
+		return fmt.Errorf("header at %d has invalid target %064x", h.BlockHeight, target)
+	}
+	powHash := header.BlockHash()
+	if net.PowHash != nil {
+		if powHash, err = net.PowHash(header); err != nil {
+			return err
+		}
+	}
+	if blockchain.HashToBig(&powHash).Cmp(target) > 0 {
+		return fmt.Errorf("header at %d doesn't satisfy its proof of work", h.BlockHeight)
+	}
+	return nil
+}
+
+// scryptPowHash is the proof of work hash used by Litecoin.
+func scryptPowHash(header *wire.BlockHeader) (chainhash.Hash, error) {
+	var buf bytes.Buffer
+	if err := header.Serialize(&buf); err != nil {
+		return chainhash.Hash{}, err
+	}
+	b, err := scrypt.Key(buf.Bytes(), buf.Bytes(), 1024, 1, 1, chainhash.HashSize)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	var hash chainhash.Hash
+	copy(hash[:], b)
+	return hash, nil
+}
+
+func newHashFromStr(s string) *chainhash.Hash {
+	hash, err := chainhash.NewHashFromStr(s)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+func newPowLimit(s string) *big.Int {
+	limit, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid pow limit " + s)
+	}
+	return limit
+}
+
+// testNet4Params are the BIP 94 testnet4 parameters which btcd doesn't ship.
+// Only the fields needed for addresses and headers are set.
+var testNet4Params = func() chaincfg.Params {
+	params := chaincfg.TestNet3Params
+	params.Name = "testnet4"
+	params.Net = wire.BitcoinNet(0x283f161c)
+	params.DefaultPort = "48333"
+	params.DNSSeeds = nil
+	params.Checkpoints = nil
+	params.GenesisBlock = nil
+	params.GenesisHash = newHashFromStr("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043")
+	return params
+}()
+
+// litecoinMainNetParams are the Litecoin parameters needed for addresses and
+// headers.
+var litecoinMainNetParams = chaincfg.Params{
+	Name:             "litecoin",
+	Net:              wire.BitcoinNet(0xdbb6c0fb),
+	DefaultPort:      "9333",
+	GenesisHash:      newHashFromStr("12a765e31ffd4059bada1e25190f6e98c99d9714d334efa41a195a7e7e04bfe2"),
+	PowLimit:         newPowLimit("00000fffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	PowLimitBits:     0x1e0fffff,
+	CoinbaseMaturity: 100,
+	Bech32HRPSegwit:  "ltc",
+	PubKeyHashAddrID: 0x30,
+	ScriptHashAddrID: 0x32,
+	PrivateKeyID:     0xb0,
+	HDPrivateKeyID:   [4]byte{0x04, 0x88, 0xad, 0xe4},
+	HDPublicKeyID:    [4]byte{0x04, 0x88, 0xb2, 0x1e},
+	HDCoinType:       2,
+}
@@ -0,0 +1,77 @@
+package electrum
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestNetworkByGenesis(t *testing.T) {
+	for _, net := range []*Network{MainNet, TestNet3, TestNet4, SigNet, RegTest, LitecoinMainNet} {
+		got, err := NetworkByGenesis(net.Params.GenesisHash.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != net {
+			t.Errorf("NetworkByGenesis(%s) = %s", net.Name(), got.Name())
+		}
+	}
+}
+
+func TestNetworkDecodeAddress(t *testing.T) {
+	cases := []struct {
+		net     *Network
+		address string
+		valid   bool
+	}{
+		{MainNet, "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", true},
+		{TestNet3, "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", false},
+		{TestNet4, "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", true},
+		{LitecoinMainNet, "LM2WMpR1Rp6j3Sa59cMXMs1SPzj9eXpGc1", true},
+		{MainNet, "LM2WMpR1Rp6j3Sa59cMXMs1SPzj9eXpGc1", false},
+		{LitecoinMainNet, "ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7kgmn4n9", true},
+		{MainNet, "ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7kgmn4n9", false},
+	}
+	for _, c := range cases {
+		_, err := c.net.DecodeAddress(c.address)
+		if (err == nil) != c.valid {
+			t.Errorf("%s.DecodeAddress(%s) = %v", c.net.Name(), c.address, err)
+		}
+	}
+}
+
+func TestNetworksNotRegistered(t *testing.T) {
+	if chaincfg.IsBech32SegwitPrefix("ltc1") {
+		t.Errorf("litecoin params registered with chaincfg")
+	}
+}
+
+func TestCheckHeader(t *testing.T) {
+	var h BlockchainHeader
+	if err := json.Unmarshal([]byte(`{"height": 0, "hex": "`+genesisHeaderHex+`"}`), &h); err != nil {
+		t.Fatal(err)
+	}
+	if err := MainNet.CheckHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	h.Raw.Nonce++
+	h.Raw.Hash = h.Raw.BlockHash()
+	if err := MainNet.CheckHeader(&h); err == nil {
+		t.Fatal("expected proof of work error")
+	}
+}
+
+func TestCheckNetwork(t *testing.T) {
+	n, ft := newFakeNode()
+	ft.handle("server.features", func([]interface{}) (interface{}, string) {
+		return map[string]interface{}{"genesis_hash": genesisHash}, ""
+	})
+	if err := n.CheckNetwork(); err != nil {
+		t.Fatal(err)
+	}
+	n.Network = TestNet3
+	if err := n.CheckNetwork(); err == nil {
+		t.Fatal("expected genesis mismatch")
+	}
+}
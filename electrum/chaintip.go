@@ -0,0 +1,234 @@
+package electrum
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// DefaultReorgDepth is the number of headers kept by a ChainTracker to find
+// fork points.
+const DefaultReorgDepth = 100
+
+// ChainEvent is emitted by a ChainTracker. It is either a NewTip or a Reorg.
+type ChainEvent interface {
+	chainEvent()
+}
+
+// NewTip is emitted when a header extends the current chain.
+type NewTip struct {
+	Header *BlockchainHeader
+}
+
+// Reorg is emitted when the chain switches to a different branch. The headers
+// above ForkHeight in Disconnected were replaced by the headers in Connected,
+// both in ascending height order.
+type Reorg struct {
+	ForkHeight   uint64
+	Disconnected []*BlockchainHeader
+	Connected    []*BlockchainHeader
+}
+
+func (NewTip) chainEvent() {}
+func (Reorg) chainEvent()  {}
+
+// ChainTracker follows the chain tip of a node and detects reorganizations.
+type ChainTracker struct {
+	node    *Node
+	headers *HeaderStore
+	events  chan ChainEvent
+
+	subscribersLock sync.Mutex
+	subscribers     []chan ChainEvent
+	closed          bool
+}
+
+// TrackChainTip subscribes to new headers and returns a tracker emitting chain
+// events. The tracker stores headers in n.Headers, creating it if needed, and
+// invalidates n.Cache on reorgs. Its channels are closed once the node is
+// closed.
+func (n *Node) TrackChainTip() (*ChainTracker, error) {
+	headerChan, err := n.BlockchainHeadersSubscribe()
+	if err != nil {
+		return nil, err
+	}
+	if n.Headers == nil {
+		n.Headers = NewHeaderStore(DefaultReorgDepth)
+	}
+	t := &ChainTracker{
+		node:    n,
+		headers: n.Headers,
+		events:  make(chan ChainEvent, pushBufferSize),
+	}
+	go func() {
+		defer t.close()
+		for header := range headerChan {
+			if err := t.process(header); err != nil {
+				log.Printf("ERR chain tracker %s", err)
+			}
+		}
+	}()
+	return t, nil
+}
+
+// Events returns the channel of chain events. Events are dropped if it isn't
+// drained.
+func (t *ChainTracker) Events() <-chan ChainEvent {
+	return t.events
+}
+
+// Subscribe returns an additional channel receiving all future chain events.
+// Events are dropped if it isn't drained. Call Unsubscribe once done.
+func (t *ChainTracker) Subscribe() <-chan ChainEvent {
+	c := make(chan ChainEvent, pushBufferSize)
+	t.subscribersLock.Lock()
+	defer t.subscribersLock.Unlock()
+	if t.closed {
+		close(c)
+		return c
+	}
+	t.subscribers = append(t.subscribers, c)
+	return c
+}
+
+// Unsubscribe stops sending events to a channel returned by Subscribe and
+// closes it.
+func (t *ChainTracker) Unsubscribe(c <-chan ChainEvent) {
+	t.subscribersLock.Lock()
+	defer t.subscribersLock.Unlock()
+	for i, sub := range t.subscribers {
+		if sub == c {
+			t.subscribers = append(t.subscribers[:i:i], t.subscribers[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
+
+// emit sends an event to Events and all subscribers.
+func (t *ChainTracker) emit(ev ChainEvent) {
+	t.subscribersLock.Lock()
+	defer t.subscribersLock.Unlock()
+	for _, c := range append([]chan ChainEvent{t.events}, t.subscribers...) {
+		select {
+		case c <- ev:
+		default:
+		}
+	}
+}
+
+// close closes Events and all subscribers once the header subscription ends.
+func (t *ChainTracker) close() {
+	t.subscribersLock.Lock()
+	defer t.subscribersLock.Unlock()
+	t.closed = true
+	close(t.events)
+	for _, c := range t.subscribers {
+		close(c)
+	}
+	t.subscribers = nil
+}
+
+// Headers returns the headers known to the tracker.
+func (t *ChainTracker) Headers() *HeaderStore {
+	return t.headers
+}
+
+// process handles a header announced by the server.
+func (t *ChainTracker) process(header *BlockchainHeader) error {
+	if header == nil {
+		return nil
+	}
+	hash, err := header.BlockHash()
+	if err != nil {
+		return err
+	}
+	if err := t.node.Network.CheckHeader(header); err != nil {
+		return t.node.misbehaved(InvalidHeader, err)
+	}
+	tip := t.headers.Tip()
+	if tip == nil {
+		t.headers.Put(header)
+		t.emit(NewTip{Header: header})
+		return nil
+	}
+	if known := t.headers.Get(header.BlockHeight); known != nil {
+		if knownHash, err := known.BlockHash(); err == nil && knownHash == hash {
+			return nil
+		}
+	}
+
+	// Walk back from the new header until it connects to a stored header.
+	connected := []*BlockchainHeader{header}
+	cur := header
+	for {
+		if cur.BlockHeight == 0 {
+			return fmt.Errorf("header %s doesn't connect to the chain", hash)
+		}
+		height := cur.BlockHeight - 1
+		if stored := t.headers.Get(height); stored != nil {
+			storedHash, err := stored.BlockHash()
+			if err != nil {
+				return err
+			}
+			if storedHash.String() == cur.PrevBlockHash {
+				break
+			}
+		} else if height < t.headers.Lowest() {
+			// Headers we never saw can't be disconnected.
+			break
+		}
+		if tip.BlockHeight > height+t.headers.depth {
+			return fmt.Errorf("reorg at %d deeper than %d blocks", tip.BlockHeight, t.headers.depth)
+		}
+		prev, err := t.fetchHeader(height, header.Raw != nil)
+		if err != nil {
+			return err
+		}
+		if prev == nil {
+			return fmt.Errorf("server returned no header at height %d", height)
+		}
+		prevHash, err := prev.BlockHash()
+		if err != nil {
+			return err
+		}
+		if prevHash.String() != cur.PrevBlockHash {
+			return fmt.Errorf("header at height %d doesn't match prev hash %s", height, cur.PrevBlockHash)
+		}
+		if err := t.node.Network.CheckHeader(prev); err != nil {
+			return t.node.misbehaved(InvalidHeader, err)
+		}
+		connected = append([]*BlockchainHeader{prev}, connected...)
+		cur = prev
+	}
+
+	forkHeight := cur.BlockHeight - 1
+	disconnected := t.headers.Rewind(forkHeight)
+	if len(disconnected) > 0 && t.node.Cache != nil {
+		t.node.Cache.InvalidateFrom(forkHeight + 1)
+	}
+	for _, h := range connected {
+		t.headers.Put(h)
+	}
+	if len(disconnected) == 0 {
+		for _, h := range connected {
+			t.emit(NewTip{Header: h})
+		}
+		return nil
+	}
+	t.emit(Reorg{
+		ForkHeight:   forkHeight,
+		Disconnected: disconnected,
+		Connected:    connected,
+	})
+	return nil
+}
+
+// fetchHeader requests the header at height in the same format the server
+// announces new headers in.
+func (t *ChainTracker) fetchHeader(height uint64, raw bool) (*BlockchainHeader, error) {
+	if raw {
+		return t.node.BlockchainBlockHeader(height)
+	}
+	return t.node.BlockchainBlockGetHeader(height)
+}
@@ -0,0 +1,228 @@
+package electrum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// makeChain builds count regtest headers on top of parent, using branch to
+// tell branches apart.
+func makeChain(t *testing.T, parent *BlockchainHeader, count int, branch uint64) []*BlockchainHeader {
+	var headers []*BlockchainHeader
+	prev := chainhash.Hash{}.String()
+	height := uint64(0)
+	if parent != nil {
+		hash, err := parent.BlockHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev = hash.String()
+		height = parent.BlockHeight + 1
+	}
+	for i := 0; i < count; i++ {
+		h := &BlockchainHeader{
+			PrevBlockHash: prev,
+			Timestamp:     1500000000 + height + branch*1000,
+			MerkleRoot:    chainhash.Hash{}.String(),
+			BlockHeight:   height,
+			Version:       1,
+			Bits:          0x207fffff,
+		}
+		for RegTest.CheckHeader(h) != nil {
+			h.Nonce++
+		}
+		hash, err := h.BlockHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, h)
+		prev = hash.String()
+		height++
+	}
+	return headers
+}
+
+func sameHeader(t *testing.T, a, b *BlockchainHeader) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	ha, err := a.BlockHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, err := b.BlockHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ha == hb
+}
+
+func nextEvent(t *testing.T, tracker *ChainTracker) ChainEvent {
+	select {
+	case ev := <-tracker.Events():
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for chain event")
+	}
+	return nil
+}
+
+func TestChainTrackerReorg(t *testing.T) {
+	main := makeChain(t, nil, 5, 0)
+	fork := makeChain(t, main[2], 3, 1)
+	served := main
+
+	n, ft := newFakeNode()
+	n.Network = RegTest
+	ft.handle("blockchain.headers.subscribe", func([]interface{}) (interface{}, string) {
+		return main[0], ""
+	})
+	ft.handle("blockchain.block.get_header", func(params []interface{}) (interface{}, string) {
+		return served[int(params[0].(float64))], ""
+	})
+	tracker, err := n.TrackChainTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range main {
+		if i > 0 {
+			ft.push("blockchain.headers.subscribe", h)
+		}
+		if ev, ok := nextEvent(t, tracker).(NewTip); !ok || !sameHeader(t, ev.Header, h) {
+			t.Fatalf("expected NewTip for header %d, got %+v", i, ev)
+		}
+	}
+
+	served = append(main[:3:3], fork...)
+	ft.push("blockchain.headers.subscribe", fork[2])
+	reorg, ok := nextEvent(t, tracker).(Reorg)
+	if !ok {
+		t.Fatalf("expected Reorg")
+	}
+	if reorg.ForkHeight != 2 {
+		t.Errorf("ForkHeight = %d; want 2", reorg.ForkHeight)
+	}
+	if len(reorg.Disconnected) != 2 || !sameHeader(t, reorg.Disconnected[0], main[3]) {
+		t.Errorf("Disconnected = %+v", reorg.Disconnected)
+	}
+	if len(reorg.Connected) != 3 || !sameHeader(t, reorg.Connected[2], fork[2]) {
+		t.Errorf("Connected = %+v", reorg.Connected)
+	}
+	if tip := tracker.Headers().Tip(); !sameHeader(t, tip, fork[2]) {
+		t.Errorf("tip = %+v; want %+v", tip, fork[2])
+	}
+}
+
+func TestChainTrackerSlowSubscriber(t *testing.T) {
+	main := makeChain(t, nil, pushBufferSize+2, 0)
+
+	n, ft := newFakeNode()
+	n.Network = RegTest
+	ft.handle("blockchain.headers.subscribe", func([]interface{}) (interface{}, string) {
+		return main[0], ""
+	})
+	tracker, err := n.TrackChainTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-tracker.Events()
+	tracker.Subscribe()
+	unsubscribed := tracker.Subscribe()
+	tracker.Unsubscribe(unsubscribed)
+	if _, ok := <-unsubscribed; ok {
+		t.Fatal("expected Unsubscribe to close the channel")
+	}
+
+	for _, h := range main[1:] {
+		ft.push("blockchain.headers.subscribe", h)
+		if ev, ok := nextEvent(t, tracker).(NewTip); !ok || !sameHeader(t, ev.Header, h) {
+			t.Fatalf("expected NewTip for header %d, got %+v", h.BlockHeight, ev)
+		}
+	}
+}
+
+func TestChainTrackerUndrainedEvents(t *testing.T) {
+	main := makeChain(t, nil, pushBufferSize+2, 0)
+
+	n, ft := newFakeNode()
+	n.Network = RegTest
+	ft.handle("blockchain.headers.subscribe", func([]interface{}) (interface{}, string) {
+		return main[0], ""
+	})
+	tracker, err := n.TrackChainTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Events is only read for the initial tip.
+	<-tracker.Events()
+	sub := tracker.Subscribe()
+	for _, h := range main[1:] {
+		ft.push("blockchain.headers.subscribe", h)
+		select {
+		case ev := <-sub:
+			if tip, ok := ev.(NewTip); !ok || !sameHeader(t, tip.Header, h) {
+				t.Fatalf("expected NewTip for header %d, got %+v", h.BlockHeight, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber starved at header %d", h.BlockHeight)
+		}
+	}
+
+	n.Close()
+	select {
+	case _, ok := <-sub:
+		if ok {
+			t.Fatal("expected no more events")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber not closed with the node")
+	}
+}
+
+func TestHeadersSubscribeError(t *testing.T) {
+	n, _ := newFakeNode()
+	if _, err := n.BlockchainHeadersSubscribe(); err == nil {
+		t.Fatal("expected an error")
+	}
+	n.pushHandlersLock.RLock()
+	defer n.pushHandlersLock.RUnlock()
+	if l := len(n.pushHandlers["blockchain.headers.subscribe"]); l != 0 {
+		t.Errorf("%d push listeners left after a failed subscribe", l)
+	}
+}
+
+func TestChainTrackerInvalidHeader(t *testing.T) {
+	main := makeChain(t, nil, 2, 0)
+	invalid := *main[1]
+	for invalid.Nonce = 0; RegTest.CheckHeader(&invalid) == nil; invalid.Nonce++ {
+	}
+
+	n, ft := newFakeNode()
+	n.Network = RegTest
+	misbehaved := make(chan Misbehavior, 1)
+	n.OnMisbehavior = func(kind Misbehavior, err error) {
+		misbehaved <- kind
+	}
+	ft.handle("blockchain.headers.subscribe", func([]interface{}) (interface{}, string) {
+		return main[0], ""
+	})
+	tracker, err := n.TrackChainTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-tracker.Events()
+	ft.push("blockchain.headers.subscribe", &invalid)
+	select {
+	case kind := <-misbehaved:
+		if kind != InvalidHeader {
+			t.Errorf("misbehavior = %s", kind)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("invalid header wasn't reported")
+	}
+	if tip := tracker.Headers().Tip(); !sameHeader(t, tip, main[0]) {
+		t.Errorf("tip = %+v", tip)
+	}
+}
@@ -0,0 +1,208 @@
+package electrum
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// QuorumPolicy decides whether agree out of total queried servers returning
+// the same answer is enough to accept it.
+type QuorumPolicy func(agree, total int) bool
+
+// Majority requires more than half of the servers to agree.
+func Majority(agree, total int) bool {
+	return agree*2 > total
+}
+
+// Unanimous requires every server to agree.
+func Unanimous(agree, total int) bool {
+	return agree == total
+}
+
+// AtLeast requires at least n servers to agree.
+func AtLeast(n int) QuorumPolicy {
+	return func(agree, total int) bool {
+		return agree >= n
+	}
+}
+
+// ConsensusReport describes how each server answered a consensus query.
+// Servers are identified by their address.
+type ConsensusReport struct {
+	Agreeing    []string
+	Disagreeing []string
+	Failed      map[string]error
+}
+
+// Consensus sends the same query to several independent servers and only
+// returns answers a quorum of them agree on.
+type Consensus struct {
+	Nodes  []*Node
+	Quorum QuorumPolicy
+}
+
+// NewConsensus creates a consensus client over the nodes requiring a
+// majority.
+func NewConsensus(nodes ...*Node) *Consensus {
+	return &Consensus{
+		Nodes:  nodes,
+		Quorum: Majority,
+	}
+}
+
+// BlockchainAddressGetHistory returns the history of an address agreed on by
+// a quorum of servers. Mempool entries are ignored when comparing since they
+// legitimately differ between servers.
+func (c *Consensus) BlockchainAddressGetHistory(address string) ([]*HistoryItem, *ConsensusReport, error) {
+	result, report, err := c.query(func(n *Node) (interface{}, interface{}, error) {
+		history, err := n.BlockchainAddressGetHistory(address)
+		if err != nil {
+			return nil, nil, err
+		}
+		return history, confirmedHistory(history), nil
+	})
+	if err != nil {
+		return nil, report, err
+	}
+	return result.([]*HistoryItem), report, nil
+}
+
+// BlockchainAddressListUnspent returns the unspent outputs of an address
+// agreed on by a quorum of servers. Mempool entries are ignored when
+// comparing.
+func (c *Consensus) BlockchainAddressListUnspent(address string) ([]*UTXO, *ConsensusReport, error) {
+	result, report, err := c.query(func(n *Node) (interface{}, interface{}, error) {
+		unspent, err := n.BlockchainAddressListUnspent(address)
+		if err != nil {
+			return nil, nil, err
+		}
+		return unspent, confirmedUTXOs(unspent), nil
+	})
+	if err != nil {
+		return nil, report, err
+	}
+	return result.([]*UTXO), report, nil
+}
+
+// BlockchainAddressGetBalance returns the balance of an address agreed on by
+// a quorum of servers. Only the confirmed balance is compared.
+func (c *Consensus) BlockchainAddressGetBalance(address string) (*Balance, *ConsensusReport, error) {
+	result, report, err := c.query(func(n *Node) (interface{}, interface{}, error) {
+		balance, err := n.BlockchainAddressGetBalance(address)
+		if err != nil {
+			return nil, nil, err
+		}
+		if balance == nil {
+			return nil, nil, ErrEmptyResult
+		}
+		return balance, balance.Confirmed, nil
+	})
+	if err != nil {
+		return nil, report, err
+	}
+	return result.(*Balance), report, nil
+}
+
+// query runs f against every node concurrently. f returns the result and a
+// normalized form of it which is compared between servers.
+func (c *Consensus) query(f func(n *Node) (interface{}, interface{}, error)) (interface{}, *ConsensusReport, error) {
+	type answer struct {
+		node   *Node
+		result interface{}
+		key    string
+		err    error
+	}
+	answers := make([]answer, len(c.Nodes))
+	var wg sync.WaitGroup
+	for i, n := range c.Nodes {
+		wg.Add(1)
+		go func(i int, n *Node) {
+			defer wg.Done()
+			a := answer{node: n}
+			var normalized interface{}
+			a.result, normalized, a.err = f(n)
+			if a.err == nil {
+				var key []byte
+				key, a.err = json.Marshal(normalized)
+				a.key = string(key)
+			}
+			answers[i] = a
+		}(i, n)
+	}
+	wg.Wait()
+
+	counts := make(map[string]int)
+	best := ""
+	for _, a := range answers {
+		if a.err != nil {
+			continue
+		}
+		counts[a.key]++
+		if counts[a.key] > counts[best] {
+			best = a.key
+		}
+	}
+
+	report := &ConsensusReport{Failed: make(map[string]error)}
+	var result interface{}
+	for _, a := range answers {
+		switch {
+		case a.err != nil:
+			report.Failed[a.node.Address] = a.err
+		case a.key == best:
+			report.Agreeing = append(report.Agreeing, a.node.Address)
+			if result == nil {
+				result = a.result
+			}
+		default:
+			report.Disagreeing = append(report.Disagreeing, a.node.Address)
+		}
+	}
+
+	quorum := c.Quorum
+	if quorum == nil {
+		quorum = Majority
+	}
+	if len(report.Agreeing) == 0 || !quorum(len(report.Agreeing), len(c.Nodes)) {
+		return nil, report, ErrNoQuorum
+	}
+	return result, report, nil
+}
+
+// confirmedHistory returns the confirmed history items sorted by height and
+// txid.
+func confirmedHistory(history []*HistoryItem) []*HistoryItem {
+	var confirmed []*HistoryItem
+	for _, item := range history {
+		if item.State == Confirmed {
+			confirmed = append(confirmed, item)
+		}
+	}
+	sort.Slice(confirmed, func(i, j int) bool {
+		a, b := confirmed[i], confirmed[j]
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		return a.TxID.String() < b.TxID.String()
+	})
+	return confirmed
+}
+
+// confirmedUTXOs returns the confirmed unspent outputs sorted by outpoint.
+func confirmedUTXOs(utxos []*UTXO) []*UTXO {
+	var confirmed []*UTXO
+	for _, utxo := range utxos {
+		if utxo.State == Confirmed {
+			confirmed = append(confirmed, utxo)
+		}
+	}
+	sort.Slice(confirmed, func(i, j int) bool {
+		a, b := confirmed[i].OutPoint, confirmed[j].OutPoint
+		if a.Hash != b.Hash {
+			return a.Hash.String() < b.Hash.String()
+		}
+		return a.Index < b.Index
+	})
+	return confirmed
+}
@@ -0,0 +1,46 @@
+package electrum
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+func TestConsensusHistory(t *testing.T) {
+	honest := []*HistoryItem{
+		{TxID: chainhash.Hash{0xaa}, Height: 100},
+		{TxID: chainhash.Hash{0xbb}, Height: 200},
+	}
+	liar := []*HistoryItem{
+		{TxID: chainhash.Hash{0xaa}, Height: 100},
+	}
+	withMempool := append([]*HistoryItem{{TxID: chainhash.Hash{0xcc}, State: Mempool, Fee: 1000}}, honest[1], honest[0])
+
+	var nodes []*Node
+	for i, history := range [][]*HistoryItem{honest, liar, withMempool} {
+		n, ft := newFakeNode()
+		n.Address = string(rune('a' + i))
+		history := history
+		ft.handle("blockchain.address.get_history", func([]interface{}) (interface{}, string) {
+			return history, ""
+		})
+		nodes = append(nodes, n)
+	}
+
+	c := NewConsensus(nodes...)
+	history, report, err := c.BlockchainAddressGetHistory("addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Errorf("history = %+v", history)
+	}
+	if len(report.Agreeing) != 2 || len(report.Disagreeing) != 1 || report.Disagreeing[0] != "b" {
+		t.Errorf("report = %+v", report)
+	}
+
+	c.Quorum = Unanimous
+	if _, _, err := c.BlockchainAddressGetHistory("addr"); err != ErrNoQuorum {
+		t.Errorf("expected ErrNoQuorum, got %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+package electrum
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Crawler defaults used for zero fields.
+const (
+	DefaultCrawlMaxPeers    = 500
+	DefaultCrawlConcurrency = 8
+	DefaultCrawlTimeout     = 10 * time.Second
+)
+
+// PeerProbe is what a crawl learned about one peer.
+type PeerProbe struct {
+	Peer      *Peer  `json:"peer"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+
+	ServerVersion string `json:"server_version,omitempty"`
+	// Protocol is the negotiated protocol version.
+	Protocol    string `json:"protocol,omitempty"`
+	ProtocolMax string `json:"protocol_max,omitempty"`
+	GenesisHash string `json:"genesis_hash,omitempty"`
+	// Network is the name of the registered network with the peer's genesis
+	// hash, if any.
+	Network   string        `json:"network,omitempty"`
+	TipHeight uint64        `json:"tip_height,omitempty"`
+	Latency   time.Duration `json:"latency,omitempty"`
+	// Announced are the hosts of the peers the server announced.
+	Announced []string `json:"announced,omitempty"`
+}
+
+// NetworkMap is the result of a crawl.
+type NetworkMap struct {
+	Network string `json:"network"`
+	// BestHeight is the highest tip reported by at least two peers on the
+	// network, or by the only one.
+	BestHeight uint64       `json:"best_height"`
+	Peers      []*PeerProbe `json:"peers"`
+}
+
+// WriteJSON writes the map as indented JSON.
+func (m *NetworkMap) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// Healthy returns the reachable peers on the network at most maxLag blocks
+// behind the best height, fastest first.
+func (m *NetworkMap) Healthy(maxLag uint64) []*PeerProbe {
+	var healthy []*PeerProbe
+	for _, p := range m.Peers {
+		if p.Reachable && p.Network == m.Network && p.TipHeight+maxLag >= m.BestHeight {
+			healthy = append(healthy, p)
+		}
+	}
+	sort.SliceStable(healthy, func(i, j int) bool {
+		return healthy[i].Latency < healthy[j].Latency
+	})
+	return healthy
+}
+
+// Lagging returns the reachable peers on the network more than maxLag blocks
+// behind the best height.
+func (m *NetworkMap) Lagging(maxLag uint64) []*PeerProbe {
+	var lagging []*PeerProbe
+	for _, p := range m.Peers {
+		if p.Reachable && p.Network == m.Network && p.TipHeight+maxLag < m.BestHeight {
+			lagging = append(lagging, p)
+		}
+	}
+	return lagging
+}
+
+// WrongChain returns the reachable peers serving another chain.
+func (m *NetworkMap) WrongChain() []*PeerProbe {
+	var wrong []*PeerProbe
+	for _, p := range m.Peers {
+		if p.Reachable && p.Network != m.Network {
+			wrong = append(wrong, p)
+		}
+	}
+	return wrong
+}
+
+// Crawler builds a map of the server network by recursively asking servers
+// for their peers, starting from seeds, and probing every peer found.
+type Crawler struct {
+	Network *Network
+	Seeds   []*Peer
+
+	// MaxPeers bounds the number of peers probed.
+	MaxPeers int
+	// Concurrency is the number of peers probed at once.
+	Concurrency int
+	// Timeout bounds probing a single peer.
+	Timeout time.Duration
+
+	// TLSConfig is used for SSL connections. Most servers use self-signed
+	// certificates, so it defaults to skipping verification; probes only
+	// collect public information.
+	TLSConfig *tls.Config
+	// Dialer optionally replaces the default dialer, e.g. to crawl over Tor.
+	Dialer Dialer
+
+	// connect connects to a peer, overridden in tests.
+	connect func(p *Peer) (*Node, error)
+}
+
+// NewCrawler creates a crawler for the network starting at seeds.
+func NewCrawler(net *Network, seeds ...*Peer) *Crawler {
+	return &Crawler{
+		Network: net,
+		Seeds:   seeds,
+	}
+}
+
+// Crawl probes the seeds and every peer announced by a reachable peer, up to
+// MaxPeers. If ctx is done first the partial map is returned with the
+// context's error.
+func (c *Crawler) Crawl(ctx context.Context) (*NetworkMap, error) {
+	maxPeers := c.MaxPeers
+	if maxPeers <= 0 {
+		maxPeers = DefaultCrawlMaxPeers
+	}
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultCrawlConcurrency
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[string]bool)
+	var probes []*PeerProbe
+	sem := make(chan struct{}, concurrency)
+
+	var visit func(p *Peer)
+	visit = func(p *Peer) {
+		key := strings.ToLower(p.Host)
+		mu.Lock()
+		if seen[key] || len(seen) >= maxPeers {
+			mu.Unlock()
+			return
+		}
+		seen[key] = true
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			probe, peers := c.probe(ctx, p)
+			<-sem
+			mu.Lock()
+			probes = append(probes, probe)
+			mu.Unlock()
+			for _, peer := range peers {
+				visit(peer)
+			}
+		}()
+	}
+	for _, seed := range c.Seeds {
+		visit(seed)
+	}
+	wg.Wait()
+
+	m := &NetworkMap{Network: c.Network.Name(), Peers: probes}
+	sort.Slice(m.Peers, func(i, j int) bool {
+		return strings.ToLower(m.Peers[i].Peer.Host) < strings.ToLower(m.Peers[j].Peer.Host)
+	})
+	m.BestHeight = bestHeight(m)
+	return m, ctx.Err()
+}
+
+// Discover crawls the network and returns the healthy peers, fastest first.
+func (c *Crawler) Discover(ctx context.Context) ([]*Peer, error) {
+	m, err := c.Crawl(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var peers []*Peer
+	for _, probe := range m.Healthy(crawlMaxLag) {
+		peers = append(peers, probe.Peer)
+	}
+	return peers, nil
+}
+
+// crawlMaxLag is the number of blocks a peer may be behind to count as
+// healthy for discovery, allowing for blocks found during the crawl.
+const crawlMaxLag = 2
+
+// probe connects to a peer and collects its information and peers.
+func (c *Crawler) probe(ctx context.Context, p *Peer) (*PeerProbe, []*Peer) {
+	probe := &PeerProbe{Peer: p}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCrawlTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var n *Node
+	if err := withContext(ctx, func() error {
+		node, err := c.connectPeer(p)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			node.Close()
+			return ctx.Err()
+		}
+		n = node
+		return nil
+	}); err != nil {
+		probe.Error = err.Error()
+		return probe, nil
+	}
+	defer n.Close()
+
+	// The fields are collected into info since the closure may still run
+	// after a timeout.
+	info := &PeerProbe{Peer: p}
+	var peers []*Peer
+	err := withContext(ctx, func() error {
+		// Servers disconnect clients asking for a protocol they don't
+		// support, so the version is negotiated before anything else.
+		version, protocol, err := n.ServerVersionRange(MinProtocolVersion, MaxProtocolVersion)
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		features, err := n.ServerFeatures()
+		if err != nil {
+			return err
+		}
+		latency := time.Since(start)
+		start = time.Now()
+		if err := n.ServerPing(); err == nil {
+			latency = time.Since(start)
+		}
+
+		headers, err := n.BlockchainHeadersSubscribe()
+		if err != nil {
+			return err
+		}
+		tip := <-headers
+
+		peers, err = n.ServerPeers()
+		if err != nil {
+			return err
+		}
+
+		info.ServerVersion = features.ServerVersion
+		if len(info.ServerVersion) == 0 {
+			info.ServerVersion = version
+		}
+		info.Protocol = protocol
+		info.ProtocolMax = features.ProtocolMax
+		info.GenesisHash = features.GenesisHash
+		if net, err := NetworkByGenesis(features.GenesisHash); err == nil {
+			info.Network = net.Name()
+		}
+		if tip != nil {
+			info.TipHeight = tip.BlockHeight
+		}
+		info.Latency = latency
+		return nil
+	})
+	if err != nil {
+		probe.Error = err.Error()
+		return probe, nil
+	}
+	probe = info
+	probe.Reachable = true
+	for _, peer := range peers {
+		probe.Announced = append(probe.Announced, peer.Host)
+	}
+	return probe, peers
+}
+
+// connectPeer connects to a peer with the crawler's settings.
+func (c *Crawler) connectPeer(p *Peer) (*Node, error) {
+	if c.connect != nil {
+		return c.connect(p)
+	}
+	n := NewNode()
+	n.Network = c.Network
+	n.Dialer = c.Dialer
+	config := c.TLSConfig
+	if config == nil {
+		config = &tls.Config{InsecureSkipVerify: true}
+	}
+	if err := n.ConnectPeer(p, config); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// bestHeight returns the highest tip reported by two peers on the network, or
+// the only peer's tip.
+func bestHeight(m *NetworkMap) uint64 {
+	counts := make(map[uint64]int)
+	var heights []uint64
+	for _, p := range m.Peers {
+		if p.Reachable && p.Network == m.Network {
+			counts[p.TipHeight]++
+			heights = append(heights, p.TipHeight)
+		}
+	}
+	if len(heights) == 1 {
+		return heights[0]
+	}
+	var best uint64
+	for height, count := range counts {
+		if count >= 2 && height > best {
+			best = height
+		}
+	}
+	return best
+}
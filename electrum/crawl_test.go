@@ -0,0 +1,129 @@
+package electrum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCrawler(t *testing.T) {
+	type server struct {
+		genesis string
+		height  uint64
+		peers   [][]interface{}
+	}
+	mainGenesis := MainNet.Params.GenesisHash.String()
+	servers := map[string]server{
+		"a.example": {mainGenesis, 100, [][]interface{}{
+			{"1.1.1.1", "b.example", []string{"v1.4", "s"}},
+			{"2.2.2.2", "c.example", []string{"v1.4", "t"}},
+		}},
+		"b.example": {mainGenesis, 100, [][]interface{}{
+			{"3.3.3.3", "down.example", []string{"s"}},
+			{"4.4.4.4", "A.example", []string{"s"}},
+		}},
+		"c.example": {TestNet3.Params.GenesisHash.String(), 50, nil},
+		"d.example": {mainGenesis, 90, nil},
+	}
+	// d is only known as a seed.
+	c := NewCrawler(MainNet, &Peer{Host: "a.example", SSLPort: "50002"}, &Peer{Host: "d.example", SSLPort: "50002"})
+	c.connect = func(p *Peer) (*Node, error) {
+		s, ok := servers[p.Host]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		n, ft := newFakeNode()
+		ft.handle("server.version", func([]interface{}) (interface{}, string) {
+			return []string{"fake 1.0", "1.4"}, ""
+		})
+		ft.handle("server.features", func([]interface{}) (interface{}, string) {
+			return ServerFeatures{GenesisHash: s.genesis, ServerVersion: "fake 1.0", ProtocolMax: "1.4"}, ""
+		})
+		ft.handle("server.ping", func([]interface{}) (interface{}, string) {
+			return nil, ""
+		})
+		ft.handle("blockchain.headers.subscribe", func([]interface{}) (interface{}, string) {
+			return &BlockchainHeader{BlockHeight: s.height}, ""
+		})
+		ft.handle("server.peers.subscribe", func([]interface{}) (interface{}, string) {
+			return s.peers, ""
+		})
+		return n, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := c.Crawl(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hosts []string
+	for _, p := range m.Peers {
+		hosts = append(hosts, p.Peer.Host)
+	}
+	want := []string{"a.example", "b.example", "c.example", "d.example", "down.example"}
+	if len(hosts) != len(want) {
+		t.Fatalf("hosts = %q, want %q", hosts, want)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Fatalf("hosts = %q, want %q", hosts, want)
+		}
+	}
+	if m.BestHeight != 100 {
+		t.Errorf("best height = %d", m.BestHeight)
+	}
+	if down := m.Peers[4]; down.Reachable || len(down.Error) == 0 {
+		t.Errorf("down = %+v", down)
+	}
+	if a := m.Peers[0]; a.ServerVersion != "fake 1.0" || a.Protocol != "1.4" || a.Network != "mainnet" || len(a.Announced) != 2 {
+		t.Errorf("a = %+v", a)
+	}
+	if wrong := m.WrongChain(); len(wrong) != 1 || wrong[0].Peer.Host != "c.example" {
+		t.Errorf("wrong chain = %+v", wrong)
+	}
+	if lagging := m.Lagging(crawlMaxLag); len(lagging) != 1 || lagging[0].Peer.Host != "d.example" {
+		t.Errorf("lagging = %+v", lagging)
+	}
+	if healthy := m.Healthy(crawlMaxLag); len(healthy) != 2 {
+		t.Errorf("healthy = %+v", healthy)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded NetworkMap
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Peers) != len(m.Peers) || decoded.BestHeight != 100 {
+		t.Errorf("decoded = %+v", decoded)
+	}
+}
+
+func TestCrawlerProbeVersion(t *testing.T) {
+	c := NewCrawler(MainNet)
+	var params []interface{}
+	c.connect = func(p *Peer) (*Node, error) {
+		n, ft := newFakeNode()
+		ft.handle("server.version", func(p []interface{}) (interface{}, string) {
+			params = p
+			return nil, "unsupported protocol version: 1.4"
+		})
+		return n, nil
+	}
+	probe, peers := c.probe(context.Background(), &Peer{Host: "old.example"})
+	if probe.Reachable || len(probe.Error) == 0 || len(peers) != 0 {
+		t.Errorf("probe = %+v", probe)
+	}
+	want := []interface{}{ClientVersion, []interface{}{MinProtocolVersion, MaxProtocolVersion}}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("server.version params = %v, want %v", params, want)
+	}
+}
@@ -0,0 +1,392 @@
+package electrum
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Peer describes an Electrum server as announced by servers and discovery
+// services.
+type Peer struct {
+	Host string
+	// IP is the address the peer was seen at, if known.
+	IP string
+	// Version is the highest protocol version the peer supports, if known.
+	Version string
+	// Pruning is the pruning limit in blocks, 0 if the peer isn't pruned.
+	Pruning int
+	// TCPPort and SSLPort are empty if the peer doesn't offer the transport.
+	TCPPort string
+	SSLPort string
+}
+
+// NewPeer creates a peer from its host and the feature tokens used by
+// server.peers.subscribe and IRC realnames, e.g. "v1.4", "p10000", "t" and
+// "s50002". Ports without a number default to the network's ports. Unknown
+// tokens are ignored.
+func NewPeer(host string, features []string, net *Network) (*Peer, error) {
+	if len(host) == 0 {
+		return nil, fmt.Errorf("peer has no host")
+	}
+	p := &Peer{Host: host}
+	for _, token := range features {
+		if len(token) == 0 {
+			continue
+		}
+		value := token[1:]
+		switch token[0] {
+		case 'v':
+			p.Version = value
+		case 'p':
+			pruning, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("peer %s has invalid pruning %q", host, token)
+			}
+			p.Pruning = pruning
+		case 't', 's':
+			port := value
+			if len(port) == 0 {
+				port = net.DefaultTCPPort
+				if token[0] == 's' {
+					port = net.DefaultSSLPort
+				}
+			} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return nil, fmt.Errorf("peer %s has invalid port %q", host, token)
+			}
+			if token[0] == 't' {
+				p.TCPPort = port
+			} else {
+				p.SSLPort = port
+			}
+		}
+	}
+	if len(p.TCPPort) == 0 && len(p.SSLPort) == 0 {
+		return nil, fmt.Errorf("peer %s has no ports", host)
+	}
+	return p, nil
+}
+
+// ParsePeer parses a peer descriptor of a host followed by feature tokens, as
+// used in IRC realnames and bootstrap lists, e.g.
+// "host.example v1.0 p10000 t s50002".
+func ParsePeer(descriptor string, net *Network) (*Peer, error) {
+	fields := strings.Fields(descriptor)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty peer descriptor")
+	}
+	return NewPeer(fields[0], fields[1:], net)
+}
+
+// String encodes the peer as a descriptor understood by ParsePeer.
+func (p *Peer) String() string {
+	tokens := []string{p.Host}
+	if len(p.Version) > 0 {
+		tokens = append(tokens, "v"+p.Version)
+	}
+	if p.Pruning > 0 {
+		tokens = append(tokens, "p"+strconv.Itoa(p.Pruning))
+	}
+	if len(p.TCPPort) > 0 {
+		tokens = append(tokens, "t"+p.TCPPort)
+	}
+	if len(p.SSLPort) > 0 {
+		tokens = append(tokens, "s"+p.SSLPort)
+	}
+	return strings.Join(tokens, " ")
+}
+
+// TCPAddress returns the host:port to connect to over TCP, or "" if the peer
+// has no TCP port.
+func (p *Peer) TCPAddress() string {
+	if len(p.TCPPort) == 0 {
+		return ""
+	}
+	return net.JoinHostPort(p.Host, p.TCPPort)
+}
+
+// SSLAddress returns the host:port to connect to over SSL, or "" if the peer
+// has no SSL port.
+func (p *Peer) SSLAddress() string {
+	if len(p.SSLPort) == 0 {
+		return ""
+	}
+	return net.JoinHostPort(p.Host, p.SSLPort)
+}
+
+// ConnectPeer connects to a peer, preferring SSL. A nil config verifies the
+// certificate against the peer's host.
+func (n *Node) ConnectPeer(p *Peer, config *tls.Config) error {
+	if addr := p.SSLAddress(); len(addr) > 0 {
+		if config == nil {
+			config = &tls.Config{ServerName: p.Host}
+		}
+		return n.ConnectSSL(addr, config)
+	}
+	return n.ConnectTCP(p.TCPAddress())
+}
+
+// ServerPeers returns the peers known to the server.
+// http://docs.electrum.org/en/latest/protocol-methods.html#server-peers-subscribe
+func (n *Node) ServerPeers() ([]*Peer, error) {
+	entries, err := n.ServerPeersSubscribe()
+	if err != nil {
+		return nil, err
+	}
+	var peers []*Peer
+	for _, entry := range entries {
+		if len(entry) != 3 {
+			log.Printf("peers entry len != 3 %+v", entry)
+			continue
+		}
+		ip, _ := entry[0].(string)
+		host, _ := entry[1].(string)
+		tokens, _ := entry[2].([]interface{})
+		var features []string
+		for _, token := range tokens {
+			if s, ok := token.(string); ok {
+				features = append(features, s)
+			}
+		}
+		p, err := NewPeer(host, features, n.Network)
+		if err != nil {
+			log.Printf("ERR %s", err)
+			continue
+		}
+		p.IP = ip
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// Discoverer finds Electrum servers.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]*Peer, error)
+}
+
+// StaticDiscoverer returns a fixed list of peers.
+type StaticDiscoverer []*Peer
+
+func (d StaticDiscoverer) Discover(ctx context.Context) ([]*Peer, error) {
+	return d, nil
+}
+
+// BootstrapDiscoverer returns the bootstrap peers bundled with a network.
+type BootstrapDiscoverer struct {
+	Network *Network
+}
+
+func (d BootstrapDiscoverer) Discover(ctx context.Context) ([]*Peer, error) {
+	var peers []*Peer
+	for _, descriptor := range d.Network.Bootstrap {
+		p, err := ParsePeer(descriptor, d.Network)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// PeersDiscoverer asks connected servers for the peers they know about.
+type PeersDiscoverer struct {
+	Nodes []*Node
+}
+
+func (d PeersDiscoverer) Discover(ctx context.Context) ([]*Peer, error) {
+	var discoverers MultiDiscoverer
+	for _, n := range d.Nodes {
+		n := n
+		discoverers = append(discoverers, DiscovererFunc(func(ctx context.Context) ([]*Peer, error) {
+			var peers []*Peer
+			if err := withContext(ctx, func() error {
+				var err error
+				peers, err = n.ServerPeers()
+				return err
+			}); err != nil {
+				return nil, err
+			}
+			return peers, nil
+		}))
+	}
+	return discoverers.Discover(ctx)
+}
+
+// DNSSeedDiscoverer resolves DNS seeds whose addresses are servers listening
+// on the network's default TCP port. The peers are TCP only: a bare IP
+// address can't be checked against a server certificate.
+type DNSSeedDiscoverer struct {
+	Seeds   []string
+	Network *Network
+	// Resolver is used for lookups, net.DefaultResolver if nil.
+	Resolver *net.Resolver
+}
+
+func (d DNSSeedDiscoverer) Discover(ctx context.Context) ([]*Peer, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	var discoverers MultiDiscoverer
+	for _, seed := range d.Seeds {
+		seed := seed
+		discoverers = append(discoverers, DiscovererFunc(func(ctx context.Context) ([]*Peer, error) {
+			addrs, err := resolver.LookupHost(ctx, seed)
+			if err != nil {
+				return nil, err
+			}
+			var peers []*Peer
+			for _, addr := range addrs {
+				peers = append(peers, &Peer{
+					Host:    addr,
+					IP:      addr,
+					TCPPort: d.Network.DefaultTCPPort,
+				})
+			}
+			return peers, nil
+		}))
+	}
+	return discoverers.Discover(ctx)
+}
+
+// DiscovererFunc adapts a function to a Discoverer.
+type DiscovererFunc func(ctx context.Context) ([]*Peer, error)
+
+func (f DiscovererFunc) Discover(ctx context.Context) ([]*Peer, error) {
+	return f(ctx)
+}
+
+// MultiDiscoverer runs several discoverers concurrently and merges their
+// results into one ranked list. Peers found by more discoverers rank first,
+// then peers offering SSL and peers with newer protocol versions. Failing
+// discoverers are logged and ignored unless all of them fail.
+type MultiDiscoverer []Discoverer
+
+func (m MultiDiscoverer) Discover(ctx context.Context) ([]*Peer, error) {
+	type result struct {
+		peers []*Peer
+		err   error
+	}
+	results := make([]result, len(m))
+	var wg sync.WaitGroup
+	for i, d := range m {
+		wg.Add(1)
+		go func(i int, d Discoverer) {
+			defer wg.Done()
+			peers, err := d.Discover(ctx)
+			results[i] = result{peers, err}
+		}(i, d)
+	}
+	wg.Wait()
+
+	byHost := make(map[string]*Peer)
+	sources := make(map[string]int)
+	var order []string
+	var firstErr error
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			log.Printf("ERR discovery %s", r.err)
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			failed++
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, p := range r.peers {
+			key := strings.ToLower(p.Host)
+			if existing, ok := byHost[key]; ok {
+				mergePeer(existing, p)
+			} else {
+				copied := *p
+				byHost[key] = &copied
+				order = append(order, key)
+			}
+			if !seen[key] {
+				seen[key] = true
+				sources[key]++
+			}
+		}
+	}
+	if len(m) > 0 && failed == len(m) {
+		return nil, firstErr
+	}
+
+	peers := make([]*Peer, len(order))
+	for i, key := range order {
+		peers[i] = byHost[key]
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		a, b := peers[i], peers[j]
+		sa, sb := sources[strings.ToLower(a.Host)], sources[strings.ToLower(b.Host)]
+		if sa != sb {
+			return sa > sb
+		}
+		if (len(a.SSLPort) > 0) != (len(b.SSLPort) > 0) {
+			return len(a.SSLPort) > 0
+		}
+		return compareVersions(a.Version, b.Version) > 0
+	})
+	return peers, nil
+}
+
+// mergePeer fills fields of p missing in other announcements.
+func mergePeer(p, other *Peer) {
+	if len(p.IP) == 0 {
+		p.IP = other.IP
+	}
+	if compareVersions(other.Version, p.Version) > 0 {
+		p.Version = other.Version
+	}
+	if len(p.TCPPort) == 0 {
+		p.TCPPort = other.TCPPort
+	}
+	if len(p.SSLPort) == 0 {
+		p.SSLPort = other.SSLPort
+	}
+}
+
+// compareVersions compares dotted protocol versions like "1.4.2". Parts that
+// aren't numbers count as 0, so unknown versions compare lowest and are ranked
+// last.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// withContext runs f, returning early with the context's error if it's done
+// first.
+func withContext(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,118 @@
+package electrum
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNewPeer(t *testing.T) {
+	p, err := NewPeer("host.example", []string{"v1.4", "p10000", "t", "s995"}, MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Peer{Host: "host.example", Version: "1.4", Pruning: 10000, TCPPort: "50001", SSLPort: "995"}
+	if *p != want {
+		t.Errorf("peer = %+v, want %+v", p, want)
+	}
+	if p.SSLAddress() != "host.example:995" {
+		t.Errorf("ssl address = %q", p.SSLAddress())
+	}
+
+	for _, features := range [][]string{{"v1.4"}, {"t", "pfoo"}, {"s99999"}} {
+		if _, err := NewPeer("host.example", features, MainNet); err == nil {
+			t.Errorf("expected error for %q", features)
+		}
+	}
+}
+
+func TestParsePeer(t *testing.T) {
+	p, err := ParsePeer("host.example v1.0 p10000 t s50002", MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Peer{Host: "host.example", Version: "1.0", Pruning: 10000, TCPPort: "50001", SSLPort: "50002"}
+	if *p != want {
+		t.Errorf("peer = %+v, want %+v", p, want)
+	}
+	if p.String() != "host.example v1.0 p10000 t50001 s50002" {
+		t.Errorf("descriptor = %q", p.String())
+	}
+	for _, descriptor := range []string{"", "  ", "host.example v1.0"} {
+		if _, err := ParsePeer(descriptor, MainNet); err == nil {
+			t.Errorf("expected error for %q", descriptor)
+		}
+	}
+}
+
+func TestServerPeers(t *testing.T) {
+	n, ft := newFakeNode()
+	ft.handle("server.peers.subscribe", func([]interface{}) (interface{}, string) {
+		return [][]interface{}{
+			{"1.2.3.4", "a.example", []string{"v1.4", "s"}},
+			{"5.6.7.8", "bad.example", []string{"v1.4"}},
+		}, ""
+	})
+	peers, err := n.ServerPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].IP != "1.2.3.4" || peers[0].SSLPort != "50002" {
+		t.Errorf("peers = %+v", peers)
+	}
+}
+
+func TestMultiDiscoverer(t *testing.T) {
+	failing := DiscovererFunc(func(context.Context) ([]*Peer, error) {
+		return nil, errors.New("unreachable")
+	})
+	m := MultiDiscoverer{
+		StaticDiscoverer{
+			{Host: "tcp.example", Version: "1.4", TCPPort: "50001"},
+			{Host: "shared.example", Version: "1.2", TCPPort: "50001"},
+		},
+		StaticDiscoverer{
+			{Host: "Shared.example", Version: "1.4.2", SSLPort: "50002"},
+			{Host: "ssl.example", Version: "1.4", SSLPort: "50002"},
+		},
+		failing,
+	}
+	peers, err := m.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hosts []string
+	for _, p := range peers {
+		hosts = append(hosts, p.Host)
+	}
+	if len(hosts) != 3 || hosts[0] != "shared.example" || hosts[1] != "ssl.example" || hosts[2] != "tcp.example" {
+		t.Fatalf("hosts = %q", hosts)
+	}
+	if peers[0].Version != "1.4.2" || peers[0].TCPPort != "50001" || peers[0].SSLPort != "50002" {
+		t.Errorf("merged peer = %+v", peers[0])
+	}
+
+	if _, err := (MultiDiscoverer{failing}).Discover(context.Background()); err == nil {
+		t.Errorf("expected error when every discoverer fails")
+	}
+
+	peers, err = BootstrapDiscoverer{MainNet}.Discover(context.Background())
+	if err != nil || len(peers) == 0 {
+		t.Errorf("bootstrap peers = %+v, %v", peers, err)
+	}
+}
+
+func TestDNSSeedDiscovererTCPOnly(t *testing.T) {
+	peers, err := DNSSeedDiscoverer{Seeds: []string{"localhost"}, Network: MainNet}.Discover(context.Background())
+	if err != nil {
+		t.Skipf("can't resolve localhost: %s", err)
+	}
+	if len(peers) == 0 {
+		t.Fatal("expected peers")
+	}
+	for _, p := range peers {
+		if len(p.SSLAddress()) != 0 || p.TCPPort != MainNet.DefaultTCPPort {
+			t.Errorf("expected a TCP only peer on the default port, got %+v", p)
+		}
+	}
+}
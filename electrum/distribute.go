@@ -0,0 +1,148 @@
+package electrum
+
+import (
+	"crypto/rand"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// Distributor spreads the script hashes of wallet accounts over several
+// servers so no single server can cluster all addresses of an account. Each
+// script hash is always queried on the same server, and queries can be
+// delayed by a random amount to decorrelate queries made together.
+//
+// For network level privacy connect each Node through Tor, see Dialer.
+type Distributor struct {
+	Nodes []*Node
+
+	// MaxFraction is the largest fraction of an account's script hashes a
+	// single server may see. An account's first script hash is always
+	// allowed. Zero disables the limit.
+	MaxFraction float64
+
+	// MaxDelay is the upper bound of the random delay before each query. Zero
+	// disables the delay.
+	MaxDelay time.Duration
+
+	mu       sync.Mutex
+	assigned map[string]*Node
+	accounts map[string]map[*Node]int
+}
+
+// NewDistributor creates a distributor over the nodes allowing at most
+// maxFraction of an account's script hashes per server.
+func NewDistributor(maxFraction float64, nodes ...*Node) *Distributor {
+	return &Distributor{
+		Nodes:       nodes,
+		MaxFraction: maxFraction,
+	}
+}
+
+// Assign returns the server used for a script hash of an account, assigning
+// one if it doesn't have one yet. Among the servers below the account's limit
+// the least used one is picked at random. ErrTooFewServers is returned if
+// every server already has its share of the account.
+func (d *Distributor) Assign(account, scripthash string) (*Node, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.assigned == nil {
+		d.assigned = make(map[string]*Node)
+		d.accounts = make(map[string]map[*Node]int)
+	}
+	key := account + ":" + scripthash
+	if n, ok := d.assigned[key]; ok {
+		return n, nil
+	}
+	counts := d.accounts[account]
+	if counts == nil {
+		counts = make(map[*Node]int)
+		d.accounts[account] = counts
+	}
+	total := 1
+	for _, c := range counts {
+		total += c
+	}
+	limit := math.MaxInt32
+	if d.MaxFraction > 0 {
+		limit = int(math.Floor(d.MaxFraction * float64(total)))
+		if limit < 1 {
+			limit = 1
+		}
+	}
+
+	var candidates []*Node
+	for _, n := range d.Nodes {
+		c := counts[n]
+		if c >= limit {
+			continue
+		}
+		if len(candidates) > 0 && c > counts[candidates[0]] {
+			continue
+		}
+		if len(candidates) > 0 && c < counts[candidates[0]] {
+			candidates = candidates[:0]
+		}
+		candidates = append(candidates, n)
+	}
+	if len(candidates) == 0 {
+		return nil, ErrTooFewServers
+	}
+	n := candidates[randInt(len(candidates))]
+	counts[n]++
+	d.assigned[key] = n
+	return n, nil
+}
+
+// BlockchainScripthashSubscribeHistory subscribes to the history of a script
+// hash of an account on its assigned server.
+func (d *Distributor) BlockchainScripthashSubscribeHistory(account, scripthash string) (<-chan *HistoryUpdate, error) {
+	n, err := d.Assign(account, scripthash)
+	if err != nil {
+		return nil, err
+	}
+	d.delay()
+	return n.BlockchainScripthashSubscribeHistory(scripthash)
+}
+
+// BlockchainScripthashGetHistory returns the history of a script hash of an
+// account from its assigned server.
+func (d *Distributor) BlockchainScripthashGetHistory(account, scripthash string) ([]*HistoryItem, error) {
+	n, err := d.Assign(account, scripthash)
+	if err != nil {
+		return nil, err
+	}
+	d.delay()
+	return n.BlockchainScripthashGetHistory(scripthash)
+}
+
+// BlockchainScripthashListUnspent lists the unspent outputs of a script hash
+// of an account on its assigned server.
+func (d *Distributor) BlockchainScripthashListUnspent(account, scripthash string) ([]*UTXO, error) {
+	n, err := d.Assign(account, scripthash)
+	if err != nil {
+		return nil, err
+	}
+	d.delay()
+	return n.BlockchainScripthashListUnspent(scripthash)
+}
+
+// delay sleeps a random duration up to MaxDelay.
+func (d *Distributor) delay() {
+	if d.MaxDelay <= 0 {
+		return
+	}
+	time.Sleep(time.Duration(randInt(int(d.MaxDelay))))
+}
+
+// randInt returns a uniform random number in [0, n). crypto/rand is used so
+// the choices can't be predicted by servers.
+func randInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(v.Int64())
+}
@@ -0,0 +1,51 @@
+package electrum
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDistributorAssign(t *testing.T) {
+	var nodes []*Node
+	for i := 0; i < 3; i++ {
+		n, _ := newFakeNode()
+		nodes = append(nodes, n)
+	}
+	d := NewDistributor(0.5, nodes...)
+
+	counts := make(map[*Node]int)
+	for i := 0; i < 6; i++ {
+		n, err := d.Assign("account", strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		counts[n]++
+		for _, c := range counts {
+			if i > 0 && float64(c) > 0.5*float64(i+1) {
+				t.Fatalf("%d: server has %d of %d script hashes", i, c, i+1)
+			}
+		}
+	}
+	if len(counts) != 3 {
+		t.Errorf("used %d servers", len(counts))
+	}
+
+	first, _ := d.Assign("account", "0")
+	again, _ := d.Assign("account", "0")
+	if first != again {
+		t.Errorf("script hash reassigned")
+	}
+	if _, err := d.Assign("other", "0"); err != nil {
+		t.Errorf("accounts should be limited separately: %s", err)
+	}
+
+	d = NewDistributor(0.5, nodes[:2]...)
+	for i := 0; i < 2; i++ {
+		if _, err := d.Assign("account", strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Assign("account", "2"); err != ErrTooFewServers {
+		t.Errorf("expected ErrTooFewServers, got %v", err)
+	}
+}
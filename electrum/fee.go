@@ -0,0 +1,73 @@
+package electrum
+
+import (
+	"math"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// blockVSize is the number of virtual bytes that fit into a block.
+const blockVSize = 1000000
+
+// FeeEstimator estimates fee rates by combining blockchain.estimatefee, the
+// mempool fee histogram and blockchain.relayfee.
+type FeeEstimator struct {
+	Node *Node
+}
+
+// NewFeeEstimator creates a fee estimator backed by the node.
+func NewFeeEstimator(n *Node) *FeeEstimator {
+	return &FeeEstimator{Node: n}
+}
+
+// EstimateFeeRate returns the fee rate in satoshis per virtual byte required
+// for a transaction to confirm within the given number of blocks. The
+// server's estimate is preferred, falling back to the mempool fee histogram,
+// and the result is never lower than the relay fee.
+func (f *FeeEstimator) EstimateFeeRate(blocks int) (btcutil.Amount, error) {
+	if blocks < 1 {
+		blocks = 1
+	}
+	var rate float64
+	perKB, err := f.Node.BlockchainEstimateFee(blocks)
+	if err != nil {
+		return 0, err
+	}
+	if perKB > 0 {
+		rate = perKB * btcutil.SatoshiPerBitcoin / 1000
+	} else {
+		histogram, err := f.Node.MempoolGetFeeHistogram()
+		if err != nil {
+			return 0, err
+		}
+		rate = histogramFeeRate(histogram, blocks)
+	}
+
+	relayPerKB, err := f.Node.BlockchainRelayFee()
+	if err != nil {
+		return 0, err
+	}
+	relay := float64(relayPerKB) / 1000
+	if rate < relay {
+		rate = relay
+	}
+	if rate <= 0 {
+		return 0, ErrNoFeeEstimate
+	}
+	return btcutil.Amount(math.Ceil(rate)), nil
+}
+
+// histogramFeeRate returns the lowest fee rate that is still within the first
+// blocks worth of mempool transactions. If the mempool would be cleared
+// within that many blocks it returns 0 so that the relay fee applies.
+func histogramFeeRate(histogram []FeeHistogramEntry, blocks int) float64 {
+	target := int64(blocks) * blockVSize
+	var size int64
+	for _, entry := range histogram {
+		size += entry.VSize
+		if size > target {
+			return entry.FeeRate
+		}
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package electrum
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestEstimateFeeRate(t *testing.T) {
+	cases := []struct {
+		estimate float64
+		relay    float64
+		want     btcutil.Amount
+	}{
+		// 0.0002 BTC/kB = 20 sat/vB.
+		{0.0002, 0.00001, 20},
+		// Server can't estimate, 1.5 blocks worth of mempool above 30 sat/vB.
+		{-1, 0.00001, 30},
+		// Relay fee floor.
+		{0.000001, 0.00002, 2},
+	}
+	for i, c := range cases {
+		n, ft := newFakeNode()
+		estimate, relay := c.estimate, c.relay
+		ft.handle("blockchain.estimatefee", func([]interface{}) (interface{}, string) {
+			return estimate, ""
+		})
+		ft.handle("blockchain.relayfee", func([]interface{}) (interface{}, string) {
+			return relay, ""
+		})
+		ft.handle("mempool.get_fee_histogram", func([]interface{}) (interface{}, string) {
+			return [][]float64{{50, 500000}, {30, 1000000}, {10, 2000000}}, ""
+		})
+		got, err := NewFeeEstimator(n).EstimateFeeRate(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%d. EstimateFeeRate = %d; want %d", i, got, c.want)
+		}
+	}
+}
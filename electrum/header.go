@@ -0,0 +1,221 @@
+package electrum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// RawBlockHeader is a block header decoded from its 80 byte serialization.
+type RawBlockHeader struct {
+	wire.BlockHeader
+	Height uint64
+	Hash   chainhash.Hash
+}
+
+// ParseRawBlockHeader decodes a hex encoded 80 byte block header.
+func ParseRawBlockHeader(hexHeader string, height uint64) (*RawBlockHeader, error) {
+	b, err := hex.DecodeString(hexHeader)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != wire.MaxBlockHeaderPayload {
+		return nil, fmt.Errorf("block header len %d != %d", len(b), wire.MaxBlockHeaderPayload)
+	}
+	raw := &RawBlockHeader{Height: height}
+	if err := raw.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	raw.Hash = raw.BlockHash()
+	return raw, nil
+}
+
+// BlockchainHeader converts the raw header into a BlockchainHeader with the
+// legacy fields filled in.
+func (raw *RawBlockHeader) BlockchainHeader() *BlockchainHeader {
+	return &BlockchainHeader{
+		Nonce:         uint64(raw.Nonce),
+		PrevBlockHash: raw.PrevBlock.String(),
+		Timestamp:     uint64(raw.Timestamp.Unix()),
+		MerkleRoot:    raw.MerkleRoot.String(),
+		BlockHeight:   raw.Height,
+		Version:       int(raw.Version),
+		Bits:          uint64(raw.Bits),
+		Raw:           raw,
+	}
+}
+
+// UnmarshalJSON decodes both the legacy dict header format and the
+// {"height": N, "hex": "..."} format of protocol 1.2+.
+func (h *BlockchainHeader) UnmarshalJSON(b []byte) error {
+	type legacyHeader BlockchainHeader
+	v := &struct {
+		*legacyHeader
+		Height uint64 `json:"height"`
+		Hex    string `json:"hex"`
+	}{legacyHeader: (*legacyHeader)(h)}
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+	if len(v.Hex) == 0 {
+		return nil
+	}
+	raw, err := ParseRawBlockHeader(v.Hex, v.Height)
+	if err != nil {
+		return err
+	}
+	*h = *raw.BlockchainHeader()
+	return nil
+}
+
+// MarshalJSON encodes raw headers in the {"height": N, "hex": "..."} format
+// and others in the legacy format.
+func (h *BlockchainHeader) MarshalJSON() ([]byte, error) {
+	type legacyHeader BlockchainHeader
+	if h.Raw == nil {
+		return json.Marshal((*legacyHeader)(h))
+	}
+	var buf bytes.Buffer
+	if err := h.Raw.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"height": h.Raw.Height,
+		"hex":    hex.EncodeToString(buf.Bytes()),
+	})
+}
+
+// WireHeader converts the header into its wire representation.
+func (h *BlockchainHeader) WireHeader() (*wire.BlockHeader, error) {
+	if h.Raw != nil {
+		header := h.Raw.BlockHeader
+		return &header, nil
+	}
+	prev, err := chainhash.NewHashFromStr(h.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	merkle, err := chainhash.NewHashFromStr(h.MerkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	return &wire.BlockHeader{
+		Version:    int32(h.Version),
+		PrevBlock:  *prev,
+		MerkleRoot: *merkle,
+		Timestamp:  time.Unix(int64(h.Timestamp), 0),
+		Bits:       uint32(h.Bits),
+		Nonce:      uint32(h.Nonce),
+	}, nil
+}
+
+// BlockHash computes the hash of the block the header belongs to.
+func (h *BlockchainHeader) BlockHash() (chainhash.Hash, error) {
+	if h.Raw != nil {
+		return h.Raw.Hash, nil
+	}
+	header, err := h.WireHeader()
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	return header.BlockHash(), nil
+}
+
+// HeaderStore keeps the most recent block headers indexed by height.
+type HeaderStore struct {
+	depth uint64
+
+	mu      sync.RWMutex
+	headers map[uint64]*BlockchainHeader
+	tip     *BlockchainHeader
+}
+
+// NewHeaderStore creates a header store which retains depth headers below the
+// tip.
+func NewHeaderStore(depth uint64) *HeaderStore {
+	return &HeaderStore{
+		depth:   depth,
+		headers: make(map[uint64]*BlockchainHeader),
+	}
+}
+
+// Get returns the header at the given height or nil if it isn't stored.
+func (s *HeaderStore) Get(height uint64) *BlockchainHeader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.headers[height]
+}
+
+// Tip returns the highest stored header.
+func (s *HeaderStore) Tip() *BlockchainHeader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tip
+}
+
+// Lowest returns the height of the lowest stored header.
+func (s *HeaderStore) Lowest() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var lowest uint64
+	first := true
+	for height := range s.headers {
+		if first || height < lowest {
+			lowest = height
+			first = false
+		}
+	}
+	return lowest
+}
+
+// IsFinal returns whether height is buried deeper than the reorg depth of the
+// store.
+func (s *HeaderStore) IsFinal(height uint64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tip != nil && height+s.depth <= s.tip.BlockHeight
+}
+
+// Put stores a header, advancing the tip and pruning old headers if needed.
+func (s *HeaderStore) Put(h *BlockchainHeader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers[h.BlockHeight] = h
+	if s.tip != nil && h.BlockHeight < s.tip.BlockHeight {
+		return
+	}
+	s.tip = h
+	if h.BlockHeight < s.depth {
+		return
+	}
+	for height := range s.headers {
+		if height < h.BlockHeight-s.depth {
+			delete(s.headers, height)
+		}
+	}
+}
+
+// Rewind removes all headers above height and returns them in ascending
+// order.
+func (s *HeaderStore) Rewind(height uint64) []*BlockchainHeader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed []*BlockchainHeader
+	if s.tip == nil {
+		return nil
+	}
+	for i := height + 1; i <= s.tip.BlockHeight; i++ {
+		if h, ok := s.headers[i]; ok {
+			removed = append(removed, h)
+			delete(s.headers, i)
+		}
+	}
+	s.tip = s.headers[height]
+	return removed
+}
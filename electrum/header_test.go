@@ -0,0 +1,42 @@
+package electrum
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const (
+	genesisHeaderHex = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c"
+	genesisHash      = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+)
+
+func TestBlockchainHeaderFormats(t *testing.T) {
+	cases := []string{
+		`{"height": 0, "hex": "` + genesisHeaderHex + `"}`,
+		`{"nonce": 2083236893, "prev_block_hash": "0000000000000000000000000000000000000000000000000000000000000000",
+		  "timestamp": 1231006505, "merkle_root": "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+		  "block_height": 0, "version": 1, "bits": 486604799}`,
+	}
+	for i, c := range cases {
+		var h BlockchainHeader
+		if err := json.Unmarshal([]byte(c), &h); err != nil {
+			t.Fatalf("%d. %s", i, err)
+		}
+		hash, err := h.BlockHash()
+		if err != nil {
+			t.Fatalf("%d. %s", i, err)
+		}
+		if hash.String() != genesisHash {
+			t.Errorf("%d. hash = %s; want %s", i, hash, genesisHash)
+		}
+		if h.Nonce != 2083236893 || h.Bits != 486604799 || h.Timestamp != 1231006505 {
+			t.Errorf("%d. bad legacy fields %+v", i, h)
+		}
+	}
+}
+
+func TestParseRawBlockHeaderLength(t *testing.T) {
+	if _, err := ParseRawBlockHeader(genesisHeaderHex[:100], 0); err == nil {
+		t.Fatal("expected error for short header")
+	}
+}
@@ -0,0 +1,133 @@
+package electrum
+
+import (
+	"encoding/json"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TxState is the confirmation state of a transaction.
+type TxState int
+
+const (
+	// Confirmed transactions are included in a block.
+	Confirmed TxState = iota
+	// Mempool transactions are unconfirmed but all their inputs are
+	// confirmed.
+	Mempool
+	// UnconfirmedParent transactions are unconfirmed and spend outputs of
+	// other unconfirmed transactions.
+	UnconfirmedParent
+)
+
+func (s TxState) String() string {
+	switch s {
+	case Confirmed:
+		return "confirmed"
+	case Mempool:
+		return "mempool"
+	case UnconfirmedParent:
+		return "unconfirmed parent"
+	}
+	return "unknown"
+}
+
+// txState maps the heights used by the protocol to a state and block height.
+func txState(height int) (TxState, int) {
+	switch {
+	case height > 0:
+		return Confirmed, height
+	case height == 0:
+		return Mempool, 0
+	default:
+		return UnconfirmedParent, 0
+	}
+}
+
+// protocolHeight is the inverse of txState.
+func protocolHeight(state TxState, height int) int {
+	switch state {
+	case Mempool:
+		return 0
+	case UnconfirmedParent:
+		return -1
+	}
+	return height
+}
+
+// historyJSON is the wire format of history and unspent entries.
+type historyJSON struct {
+	TxHash string `json:"tx_hash"`
+	Height int    `json:"height"`
+	Fee    int64  `json:"fee,omitempty"`
+	Value  int64  `json:"value,omitempty"`
+	TxPos  uint32 `json:"tx_pos,omitempty"`
+}
+
+// HistoryItem is a transaction in the history or mempool of an address.
+type HistoryItem struct {
+	TxID  chainhash.Hash
+	State TxState
+	// Height is the block height of confirmed transactions and 0 otherwise.
+	Height int
+	// Fee is only set for unconfirmed transactions.
+	Fee btcutil.Amount
+}
+
+func (h *HistoryItem) UnmarshalJSON(b []byte) error {
+	var v historyJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	hash, err := chainhash.NewHashFromStr(v.TxHash)
+	if err != nil {
+		return err
+	}
+	h.TxID = *hash
+	h.State, h.Height = txState(v.Height)
+	h.Fee = btcutil.Amount(v.Fee)
+	return nil
+}
+
+func (h *HistoryItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(historyJSON{
+		TxHash: h.TxID.String(),
+		Height: protocolHeight(h.State, h.Height),
+		Fee:    int64(h.Fee),
+	})
+}
+
+// UTXO is an unspent output of an address.
+type UTXO struct {
+	OutPoint wire.OutPoint
+	Value    btcutil.Amount
+	State    TxState
+	// Height is the block height of confirmed outputs and 0 otherwise.
+	Height int
+}
+
+func (u *UTXO) UnmarshalJSON(b []byte) error {
+	var v historyJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	hash, err := chainhash.NewHashFromStr(v.TxHash)
+	if err != nil {
+		return err
+	}
+	u.OutPoint = wire.OutPoint{Hash: *hash, Index: v.TxPos}
+	u.Value = btcutil.Amount(v.Value)
+	u.State, u.Height = txState(v.Height)
+	return nil
+}
+
+func (u *UTXO) MarshalJSON() ([]byte, error) {
+	return json.Marshal(historyJSON{
+		TxHash: u.OutPoint.Hash.String(),
+		Height: protocolHeight(u.State, u.Height),
+		Value:  int64(u.Value),
+		TxPos:  u.OutPoint.Index,
+	})
+}
@@ -0,0 +1,52 @@
+package electrum
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHistoryItemStates(t *testing.T) {
+	const txid = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+	var history []*HistoryItem
+	err := json.Unmarshal([]byte(`[
+		{"tx_hash": "`+txid+`", "height": 100},
+		{"tx_hash": "`+txid+`", "height": 0, "fee": 250},
+		{"tx_hash": "`+txid+`", "height": -1, "fee": 300}
+	]`), &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		state  TxState
+		height int
+		fee    int64
+	}{
+		{Confirmed, 100, 0},
+		{Mempool, 0, 250},
+		{UnconfirmedParent, 0, 300},
+	}
+	for i, w := range want {
+		h := history[i]
+		if h.TxID.String() != txid || h.State != w.state || h.Height != w.height || int64(h.Fee) != w.fee {
+			t.Errorf("%d. got %+v; want %+v", i, h, w)
+		}
+	}
+}
+
+func TestUTXORoundTrip(t *testing.T) {
+	const in = `{"tx_hash":"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b","height":-1,"value":5000000000,"tx_pos":3}`
+	var u UTXO
+	if err := json.Unmarshal([]byte(in), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.OutPoint.Index != 3 || u.Value != 5000000000 || u.State != UnconfirmedParent {
+		t.Errorf("unexpected utxo %+v", u)
+	}
+	out, err := json.Marshal(&u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in {
+		t.Errorf("Marshal = %s; want %s", out, in)
+	}
+}
@@ -0,0 +1,47 @@
+package electrum
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// latencyWindowSize is the number of recent samples kept per server.
+const latencyWindowSize = 64
+
+// latencyWindow holds the most recent response times of a server.
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+// len returns the number of samples.
+func (w *latencyWindow) len() int {
+	return len(w.samples)
+}
+
+// percentile returns the nearest-rank p-th percentile (0 to 1) of the
+// samples, or false if there are none.
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	if len(w.samples) == 0 {
+		return 0, false
+	}
+	sorted := append([]time.Duration(nil), w.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i], true
+}
@@ -0,0 +1,178 @@
+package electrum
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits throttles the requests sent to a server. The zero value doesn't
+// limit anything.
+type Limits struct {
+	// Rate is the number of requests per second, refilling a bucket of Burst
+	// requests. Zero disables rate limiting.
+	Rate  float64
+	Burst int
+	// MaxInFlight is the number of requests awaiting an answer at once. Zero
+	// disables the cap.
+	MaxInFlight int
+}
+
+// DefaultLimits stays below the default limits of public ElectrumX servers.
+var DefaultLimits = Limits{
+	Rate:        20,
+	Burst:       40,
+	MaxInFlight: 10,
+}
+
+// Priority orders throttled requests. Requests of a higher priority are sent
+// first, requests of the same priority in order.
+type Priority int
+
+const (
+	// PriorityHigh is used for subscriptions and broadcasts.
+	PriorityHigh Priority = iota
+	// PriorityNormal is used for everything else.
+	PriorityNormal
+	// PriorityLow is used for bulk fetches such as histories and
+	// transactions.
+	PriorityLow
+
+	numPriorities = 3
+)
+
+// lowPriorityMethods are the methods used for bulk fetches.
+var lowPriorityMethods = map[string]bool{
+	"blockchain.address.get_history":    true,
+	"blockchain.address.get_mempool":    true,
+	"blockchain.address.listunspent":    true,
+	"blockchain.block.get_header":       true,
+	"blockchain.block.header":           true,
+	"blockchain.block.headers":          true,
+	"blockchain.scripthash.get_history": true,
+	"blockchain.scripthash.get_mempool": true,
+	"blockchain.scripthash.listunspent": true,
+	"blockchain.transaction.get":        true,
+	"blockchain.transaction.get_merkle": true,
+}
+
+// methodPriority returns the priority of requests for method.
+func methodPriority(method string) Priority {
+	switch {
+	case strings.HasSuffix(method, ".subscribe"), method == "blockchain.transaction.broadcast":
+		return PriorityHigh
+	case lowPriorityMethods[method]:
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+// limiter queues requests to stay within Limits, using a token bucket for the
+// rate.
+type limiter struct {
+	limits Limits
+
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	inFlight int
+	queues   [numPriorities][]chan struct{}
+	timer    *time.Timer
+}
+
+// newLimiter returns a limiter enforcing limits, or nil if they don't limit
+// anything.
+func newLimiter(limits Limits) *limiter {
+	if limits.Rate <= 0 && limits.MaxInFlight <= 0 {
+		return nil
+	}
+	if limits.Burst <= 0 {
+		limits.Burst = 1
+	}
+	return &limiter{
+		limits: limits,
+		tokens: float64(limits.Burst),
+		last:   time.Now(),
+	}
+}
+
+// acquire waits until a request of priority p may be sent, or returns false
+// if done is closed first. Every successful acquire must be followed by a
+// release once the request is answered.
+func (l *limiter) acquire(p Priority, done <-chan struct{}) bool {
+	c := make(chan struct{})
+	l.mu.Lock()
+	l.queues[p] = append(l.queues[p], c)
+	l.dispatchLocked()
+	l.mu.Unlock()
+	select {
+	case <-c:
+		return true
+	case <-done:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, queued := range l.queues[p] {
+		if queued == c {
+			l.queues[p] = append(l.queues[p][:i:i], l.queues[p][i+1:]...)
+			return false
+		}
+	}
+	// The request was let through meanwhile.
+	l.inFlight--
+	l.dispatchLocked()
+	return false
+}
+
+// release frees the in flight slot of an answered request.
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	l.dispatchLocked()
+	l.mu.Unlock()
+}
+
+// dispatchLocked lets queued requests through, highest priority first, while
+// there are tokens and free slots. If it runs out of tokens, it schedules
+// itself for when the next one is available.
+func (l *limiter) dispatchLocked() {
+	if l.limits.Rate > 0 {
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.limits.Rate
+		if max := float64(l.limits.Burst); l.tokens > max {
+			l.tokens = max
+		}
+		l.last = now
+	}
+	for p := range l.queues {
+		for len(l.queues[p]) > 0 {
+			if l.limits.MaxInFlight > 0 && l.inFlight >= l.limits.MaxInFlight {
+				return
+			}
+			if l.limits.Rate > 0 {
+				if l.tokens < 1 {
+					l.scheduleLocked()
+					return
+				}
+				l.tokens--
+			}
+			l.inFlight++
+			close(l.queues[p][0])
+			l.queues[p] = l.queues[p][1:]
+		}
+	}
+}
+
+func (l *limiter) scheduleLocked() {
+	if l.timer != nil {
+		return
+	}
+	wait := time.Duration((1 - l.tokens) / l.limits.Rate * float64(time.Second))
+	l.timer = time.AfterFunc(wait, func() {
+		l.mu.Lock()
+		l.timer = nil
+		l.dispatchLocked()
+		l.mu.Unlock()
+	})
+}
@@ -0,0 +1,75 @@
+package electrum
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterPriority(t *testing.T) {
+	l := newLimiter(Limits{MaxInFlight: 1})
+	l.acquire(PriorityNormal, nil)
+
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	for i, p := range []Priority{PriorityLow, PriorityLow, PriorityHigh} {
+		wg.Add(1)
+		go func(p Priority) {
+			defer wg.Done()
+			l.acquire(p, nil)
+			mu.Lock()
+			order = append(order, p)
+			mu.Unlock()
+			l.release()
+		}(p)
+		// Wait until the request is queued.
+		for queued(l) != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	l.release()
+	wg.Wait()
+	if len(order) != 3 || order[0] != PriorityHigh {
+		t.Errorf("order = %v", order)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := newLimiter(Limits{Rate: 100, Burst: 2})
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		l.acquire(PriorityNormal, nil)
+		l.release()
+	}
+	// The burst is free, the other 4 requests wait 10ms each.
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("6 requests took %s", elapsed)
+	}
+	if newLimiter(Limits{}) != nil {
+		t.Errorf("zero limits should be unlimited")
+	}
+}
+
+func TestMethodPriority(t *testing.T) {
+	for method, want := range map[string]Priority{
+		"blockchain.scripthash.subscribe":   PriorityHigh,
+		"blockchain.transaction.broadcast":  PriorityHigh,
+		"server.ping":                       PriorityNormal,
+		"blockchain.scripthash.get_history": PriorityLow,
+	} {
+		if got := methodPriority(method); got != want {
+			t.Errorf("methodPriority(%q) = %d; want %d", method, got, want)
+		}
+	}
+}
+
+func queued(l *limiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, q := range l.queues {
+		n += len(q)
+	}
+	return n
+}
@@ -0,0 +1,38 @@
+package electrum
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FeeHistogramEntry is a bucket of the mempool fee histogram: VSize virtual
+// bytes of transactions paying at least FeeRate sat/vB.
+type FeeHistogramEntry struct {
+	FeeRate float64
+	VSize   int64
+}
+
+// UnmarshalJSON decodes the [fee_rate, vsize] pair sent by the server.
+func (e *FeeHistogramEntry) UnmarshalJSON(b []byte) error {
+	var pair []float64
+	if err := json.Unmarshal(b, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("fee histogram entry len != 2 %+v", pair)
+	}
+	e.FeeRate = pair[0]
+	e.VSize = int64(pair[1])
+	return nil
+}
+
+// MempoolGetFeeHistogram returns the fee histogram of the server's mempool
+// ordered by decreasing fee rate.
+// http://docs.electrum.org/en/latest/protocol-methods.html#mempool-get-fee-histogram
+func (n *Node) MempoolGetFeeHistogram() ([]FeeHistogramEntry, error) {
+	resp := &struct {
+		Result []FeeHistogramEntry `json:"result"`
+	}{}
+	err := n.request("mempool.get_fee_histogram", nil, resp)
+	return resp.Result, err
+}
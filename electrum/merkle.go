@@ -0,0 +1,178 @@
+package electrum
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// maxHeaderLink is the most headers fetched to link an old header to the
+// header store.
+const maxHeaderLink = 2016
+
+// MerkleRoot computes the merkle root of a block from a transaction, its
+// merkle branch and its position in the block.
+func MerkleRoot(txid string, branch []string, pos int) (*chainhash.Hash, error) {
+	if pos < 0 || len(branch) < bits.UintSize && pos>>uint(len(branch)) != 0 {
+		return nil, fmt.Errorf("position %d doesn't fit a merkle branch of length %d", pos, len(branch))
+	}
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+	var buf [chainhash.HashSize * 2]byte
+	for _, b := range branch {
+		sibling, err := chainhash.NewHashFromStr(b)
+		if err != nil {
+			return nil, err
+		}
+		if pos&1 == 1 {
+			copy(buf[:chainhash.HashSize], sibling[:])
+			copy(buf[chainhash.HashSize:], hash[:])
+		} else {
+			copy(buf[:chainhash.HashSize], hash[:])
+			copy(buf[chainhash.HashSize:], sibling[:])
+		}
+		*hash = chainhash.DoubleHashH(buf[:])
+		pos >>= 1
+	}
+	return hash, nil
+}
+
+// VerifyMerkle checks that the transaction is included in the block at height
+// using the node's header store. Headers below the store are fetched from the
+// server and only trusted if they link to the lowest stored header. It returns
+// nil without verifying if the node has no header store.
+func (n *Node) VerifyMerkle(txid string, branch []string, pos int, height uint64) error {
+	if n.Headers == nil {
+		return nil
+	}
+	header, err := n.header(height)
+	if err != nil {
+		return err
+	}
+	root, err := MerkleRoot(txid, branch, pos)
+	if err != nil {
+		return err
+	}
+	if root.String() != header.MerkleRoot {
+		return n.misbehaved(InvalidProof, fmt.Errorf("merkle root %s of %s doesn't match header at %d", root, txid, height))
+	}
+	return nil
+}
+
+// header returns the header at height from the header store. Headers below
+// the store are fetched from the server along with the headers up to the
+// store, and must link to the lowest stored header by their hashes.
+func (n *Node) header(height uint64) (*BlockchainHeader, error) {
+	if header := n.Headers.Get(height); header != nil {
+		return header, nil
+	}
+	lowest := n.Headers.Lowest()
+	anchor := n.Headers.Get(lowest)
+	if anchor == nil || height > lowest {
+		return nil, fmt.Errorf("no header at height %d in the header store", height)
+	}
+	if lowest-height > maxHeaderLink {
+		return nil, fmt.Errorf("header at height %d is too far below the header store at %d", height, lowest)
+	}
+	headers, err := n.BlockchainBlockHeaders(height, uint(lowest-height))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(headers)) != lowest-height {
+		return nil, fmt.Errorf("server returned %d of %d headers", len(headers), lowest-height)
+	}
+	next, err := anchor.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(headers) - 1; i >= 0; i-- {
+		hash, err := headers[i].BlockHash()
+		if err != nil {
+			return nil, err
+		}
+		if hash != next.PrevBlock {
+			return nil, fmt.Errorf("header at height %d doesn't link to the header store", headers[i].BlockHeight)
+		}
+		if next, err = headers[i].WireHeader(); err != nil {
+			return nil, err
+		}
+	}
+	return headers[0], nil
+}
+
+// TxPosProof is a transaction hash with its merkle branch.
+type TxPosProof struct {
+	TxHash string   `json:"tx_hash"`
+	Merkle []string `json:"merkle"`
+}
+
+// BlockchainTransactionIDFromPos returns the hash of the transaction at a
+// position in the block at height.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-transaction-id-from-pos
+func (n *Node) BlockchainTransactionIDFromPos(height uint64, pos int) (string, error) {
+	resp := &basicResp{}
+	err := n.request("blockchain.transaction.id_from_pos", []interface{}{height, pos, false}, resp)
+	return resp.Result, err
+}
+
+// BlockchainTransactionIDFromPosMerkle returns the hash and merkle branch of
+// the transaction at a position in the block at height. The branch is
+// verified against the node's header store if it has one.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-transaction-id-from-pos
+func (n *Node) BlockchainTransactionIDFromPosMerkle(height uint64, pos int) (*TxPosProof, error) {
+	resp := &struct {
+		Result *TxPosProof `json:"result"`
+	}{}
+	if err := n.request("blockchain.transaction.id_from_pos", []interface{}{height, pos, true}, resp); err != nil {
+		return nil, err
+	}
+	if resp.Result == nil {
+		return nil, ErrEmptyResult
+	}
+	if err := n.VerifyMerkle(resp.Result.TxHash, resp.Result.Merkle, pos, height); err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+// ResolveOutPoint resolves a "height:index:output" reference, as used by
+// short channel IDs (which may also be written "HxIxO"), to an outpoint.
+func (n *Node) ResolveOutPoint(ref string) (*wire.OutPoint, error) {
+	parts := strings.FieldsFunc(ref, func(r rune) bool { return r == ':' || r == 'x' })
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid outpoint reference %q", ref)
+	}
+	height, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	index, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	var txid string
+	if n.Headers != nil {
+		proof, err := n.BlockchainTransactionIDFromPosMerkle(height, pos)
+		if err != nil {
+			return nil, err
+		}
+		txid = proof.TxHash
+	} else if txid, err = n.BlockchainTransactionIDFromPos(height, pos); err != nil {
+		return nil, err
+	}
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewOutPoint(hash, uint32(index)), nil
+}
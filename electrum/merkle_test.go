@@ -0,0 +1,96 @@
+package electrum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func hashPair(a, b chainhash.Hash) chainhash.Hash {
+	return chainhash.DoubleHashH(append(a[:], b[:]...))
+}
+
+func TestResolveOutPoint(t *testing.T) {
+	txs := []chainhash.Hash{
+		chainhash.DoubleHashH([]byte("a")),
+		chainhash.DoubleHashH([]byte("b")),
+		chainhash.DoubleHashH([]byte("c")),
+	}
+	left := hashPair(txs[0], txs[1])
+	right := hashPair(txs[2], txs[2])
+	root := hashPair(left, right)
+	branch := []string{txs[2].String(), left.String()}
+
+	got, err := MerkleRoot(txs[2].String(), branch, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != root {
+		t.Fatalf("MerkleRoot = %s; want %s", got, root)
+	}
+
+	n, ft := newFakeNode()
+	n.Headers = NewHeaderStore(DefaultReorgDepth)
+	n.Headers.Put(&BlockchainHeader{BlockHeight: 500000, MerkleRoot: root.String()})
+	ft.handle("blockchain.transaction.id_from_pos", func(params []interface{}) (interface{}, string) {
+		if params[0] != float64(500000) || params[1] != float64(2) || params[2] != true {
+			return nil, "bad params"
+		}
+		return TxPosProof{TxHash: txs[2].String(), Merkle: branch}, ""
+	})
+	op, err := n.ResolveOutPoint("500000x2x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Hash != txs[2] || op.Index != 1 {
+		t.Errorf("ResolveOutPoint = %s", op)
+	}
+
+	n.Headers.Put(&BlockchainHeader{BlockHeight: 500000, MerkleRoot: left.String()})
+	if _, err := n.ResolveOutPoint("500000:2:1"); err == nil {
+		t.Error("expected merkle verification error")
+	}
+}
+
+func TestMerkleRootPosition(t *testing.T) {
+	txid := chainhash.DoubleHashH([]byte("a")).String()
+	branch := []string{txid, txid}
+	for _, pos := range []int{-1, 4, 1 << 40} {
+		if _, err := MerkleRoot(txid, branch, pos); err == nil {
+			t.Errorf("MerkleRoot accepted position %d", pos)
+		}
+	}
+}
+
+func TestVerifyMerkleLinksHeaders(t *testing.T) {
+	tx := chainhash.DoubleHashH([]byte("tx"))
+	old := wire.BlockHeader{MerkleRoot: tx, Bits: 0x207fffff}
+	stored := wire.BlockHeader{PrevBlock: old.BlockHash(), Bits: 0x207fffff}
+	forged := wire.BlockHeader{MerkleRoot: chainhash.DoubleHashH([]byte("forged")), Bits: 0x207fffff}
+
+	n, ft := newFakeNode()
+	n.Headers = NewHeaderStore(DefaultReorgDepth)
+	n.Headers.Put((&RawBlockHeader{BlockHeader: stored, Height: 11, Hash: stored.BlockHash()}).BlockchainHeader())
+	serve := old
+	ft.handle("blockchain.block.headers", func(params []interface{}) (interface{}, string) {
+		if params[0] != float64(10) || params[1] != float64(1) {
+			return nil, "bad params"
+		}
+		var buf bytes.Buffer
+		serve.Serialize(&buf)
+		return map[string]interface{}{"count": 1, "hex": hex.EncodeToString(buf.Bytes()), "max": 2016}, ""
+	})
+	if err := n.VerifyMerkle(tx.String(), nil, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	serve = forged
+	if err := n.VerifyMerkle(forged.MerkleRoot.String(), nil, 0, 10); err == nil {
+		t.Error("accepted a header not linking to the store")
+	}
+	if err := n.VerifyMerkle(tx.String(), nil, 0, 12); err == nil {
+		t.Error("accepted a header above the store")
+	}
+}
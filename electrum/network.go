@@ -6,35 +6,139 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
 )
 
 const (
 	ClientVersion   = "0.0.1"
 	ProtocolVersion = "1.0"
+
+	// MinProtocolVersion and MaxProtocolVersion are the range negotiated by
+	// ServerVersionRange.
+	MinProtocolVersion = "1.4"
+	MaxProtocolVersion = "1.6"
 )
 
 var (
 	ErrNotImplemented = errors.New("not implemented")
 	ErrNodeConnected  = errors.New("node already connected")
+	ErrNoFeeEstimate  = errors.New("no fee estimate available")
+	ErrNoQuorum       = errors.New("servers didn't reach a quorum")
+	ErrEmptyResult    = errors.New("server returned an empty result")
+	ErrCoinbase       = errors.New("coinbase transactions have no prevouts")
+	ErrTooFewServers  = errors.New("not enough servers to keep the account below the per-server fraction")
+	ErrCircuitOpen    = errors.New("circuit breaker is open")
+	ErrPoolClosed     = errors.New("pool closed")
+	ErrNodeClosed     = errors.New("node closed")
+	ErrConnectionLost = errors.New("connection to the server lost")
+
+	ErrAlreadySubscribed = errors.New("already subscribed")
 )
 
 type Transport interface {
 	SendMessage([]byte) error
 	Responses() <-chan []byte
 	Errors() <-chan error
+	// Close closes the connection. Errors may stay silent afterwards.
+	Close() error
 }
 
+// ConnectionState is the state of a node's connection.
+type ConnectionState int
+
+const (
+	// Connecting is the state of a node that isn't connected yet.
+	Connecting ConnectionState = iota
+	// Connected is the state of a node with a working connection.
+	Connected
+	// Reconnecting is the state of a node that lost its connection and is
+	// redialing the server.
+	Reconnecting
+	// Closed is the final state of a node that was closed or lost its
+	// connection for good.
+	Closed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Closed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Reconnect delays, doubling after every failed attempt.
+var (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
 type respMetadata struct {
-	Id     int    `json:"id"`
-	Method string `json:"method"`
-	Error  string `json:"error"`
+	Id     int          `json:"id"`
+	Method string       `json:"method"`
+	Error  *ServerError `json:"error"`
+}
+
+// codeMethodNotFound is the JSON-RPC error code for unknown methods.
+const codeMethodNotFound = -32601
+
+// ServerError is an error returned by the server in response to a request.
+type ServerError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("error from server: %#v", e.Message)
+}
+
+// UnmarshalJSON decodes both plain string errors sent by old servers and
+// JSON-RPC error objects.
+func (e *ServerError) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &e.Message)
+	}
+	type serverError ServerError
+	return json.Unmarshal(b, (*serverError)(e))
+}
+
+// DecodeError is a response that couldn't be decoded. Sending the request
+// again won't fix it.
+type DecodeError struct {
+	Method string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("error decoding %s response: %s", e.Method, e.Err)
+}
+
+// isMethodNotFound returns whether err indicates the server doesn't support
+// the requested method.
+func isMethodNotFound(err error) bool {
+	serr, ok := err.(*ServerError)
+	if !ok {
+		return false
+	}
+	msg := strings.ToLower(serr.Message)
+	return serr.Code == codeMethodNotFound || strings.Contains(msg, "unknown method") ||
+		strings.Contains(msg, "method not found")
 }
 
 type request struct {
-	Id     int      `json:"id"`
-	Method string   `json:"method"`
-	Params []string `json:"params"`
+	Id     int           `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
 }
 
 type basicResp struct {
@@ -44,142 +148,578 @@ type basicResp struct {
 type Node struct {
 	Address string
 
-	transport    Transport
+	// Network is the chain the server is expected to serve.
+	Network *Network
+
+	// Cache optionally caches immutable responses.
+	Cache *Cache
+
+	// Headers optionally holds recent headers, e.g. from TrackChainTip. It's
+	// used to decide which data is final and to verify merkle proofs.
+	Headers *HeaderStore
+
+	// Dialer optionally replaces the default dialer for ConnectTCP and
+	// ConnectSSL, e.g. to connect through Tor.
+	Dialer Dialer
+
+	// Limits throttles the requests sent to the server. They must be set
+	// before the first request.
+	Limits Limits
+
+	// Reconnect enables redialing the server after the connection is lost.
+	// Subscriptions aren't renewed, so callers should watch StateChanges and
+	// subscribe again once the node is Connected. Requests made while
+	// Reconnecting fail with ErrConnectionLost.
+	Reconnect bool
+
+	// OnMisbehavior is called when the server provably misbehaves, i.e. sends
+	// a header failing CheckHeader or a merkle proof that doesn't verify. A
+	// Pool sets it to ban the server.
+	OnMisbehavior func(kind Misbehavior, err error)
+
+	// stateLock guards the transport and the connection state.
+	stateLock  sync.Mutex
+	transport  Transport
+	dial       func() (Transport, error)
+	state      ConnectionState
+	stateSubs  []chan ConnectionState
+	closeErr   error
+	done       chan struct{}
+	pushClosed bool
+
 	handlers     map[int]chan []byte
 	handlersLock sync.RWMutex
 
 	pushHandlers     map[string][]chan []byte
 	pushHandlersLock sync.RWMutex
 
-	nextId int
+	outpointSubs     map[wire.OutPoint]chan struct{}
+	outpointSubsLock sync.Mutex
+
+	// scripthashSubs counts the subscriptions per script hash.
+	scripthashSubs     map[string]int
+	scripthashSubsLock sync.Mutex
+
+	nextId     int
+	nextIdLock sync.Mutex
+
+	limiter     *limiter
+	limiterOnce sync.Once
 }
 
 // NewNode creates a new node.
 func NewNode() *Node {
 	n := &Node{
-		handlers:     make(map[int]chan []byte),
-		pushHandlers: make(map[string][]chan []byte),
+		Network:        MainNet,
+		handlers:       make(map[int]chan []byte),
+		pushHandlers:   make(map[string][]chan []byte),
+		outpointSubs:   make(map[wire.OutPoint]chan struct{}),
+		scripthashSubs: make(map[string]int),
+		done:           make(chan struct{}),
 	}
 	return n
 }
 
 // ConnectTCP creates a new TCP connection to the specified address.
 func (n *Node) ConnectTCP(addr string) error {
-	if n.transport != nil {
-		return ErrNodeConnected
-	}
-	n.Address = addr
-	transport, err := NewTCPTransport(addr)
-	if err != nil {
-		return err
-	}
-	n.transport = transport
-	go n.listen()
-	return nil
+	return n.connect(addr, func() (Transport, error) {
+		var transport *TCPTransport
+		var err error
+		if n.Dialer != nil {
+			transport, err = NewDialerTransport(n.Dialer, addr, nil)
+		} else {
+			transport, err = NewTCPTransport(addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return transport, nil
+	})
 }
 
 // ConnectSLL creates a new SLL connection to the specified address.
 func (n *Node) ConnectSSL(addr string, config *tls.Config) error {
-	if n.transport != nil {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	return n.connect(addr, func() (Transport, error) {
+		var transport *TCPTransport
+		var err error
+		if n.Dialer != nil {
+			transport, err = NewDialerTransport(n.Dialer, addr, config)
+		} else {
+			transport, err = NewSSLTransport(addr, config)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return transport, nil
+	})
+}
+
+// connect dials addr and starts listening. dial is kept for reconnecting.
+func (n *Node) connect(addr string, dial func() (Transport, error)) error {
+	n.stateLock.Lock()
+	switch {
+	case n.state == Closed:
+		n.stateLock.Unlock()
+		return ErrNodeClosed
+	case n.transport != nil, n.state == Reconnecting:
+		n.stateLock.Unlock()
 		return ErrNodeConnected
 	}
+	n.stateLock.Unlock()
+
 	n.Address = addr
-	transport, err := NewSSLTransport(addr, config)
+	transport, err := dial()
 	if err != nil {
 		return err
 	}
+	n.stateLock.Lock()
+	n.dial = dial
+	n.stateLock.Unlock()
+	return n.start(transport)
+}
+
+// start uses transport for the connection and starts listening.
+func (n *Node) start(transport Transport) error {
+	n.stateLock.Lock()
+	defer n.stateLock.Unlock()
+	if n.state == Closed {
+		transport.Close()
+		return ErrNodeClosed
+	}
 	n.transport = transport
-	go n.listen()
+	n.setStateLocked(Connected)
+	go n.listen(transport)
 	return nil
 }
 
-// err handles errors produced by the foreign node.
-func (n *Node) err(err error) {
-	// TODO (d4l3k) Better error handling.
-	log.Fatal(err)
+// State returns the state of the connection.
+func (n *Node) State() ConnectionState {
+	n.stateLock.Lock()
+	defer n.stateLock.Unlock()
+	return n.state
 }
 
-// listen processes messages from the server.
-func (n *Node) listen() {
-	for {
+// StateChanges returns a channel receiving every following state change. It's
+// closed once the node is closed. Changes are dropped if it isn't drained.
+func (n *Node) StateChanges() <-chan ConnectionState {
+	c := make(chan ConnectionState, pushBufferSize)
+	n.stateLock.Lock()
+	defer n.stateLock.Unlock()
+	if n.state == Closed {
+		close(c)
+		return c
+	}
+	n.stateSubs = append(n.stateSubs, c)
+	return c
+}
+
+// Done returns a channel that's closed once the node is closed.
+func (n *Node) Done() <-chan struct{} {
+	return n.done
+}
+
+// Err returns the error that closed the node, or nil if it's open or was
+// closed with Close.
+func (n *Node) Err() error {
+	n.stateLock.Lock()
+	defer n.stateLock.Unlock()
+	return n.closeErr
+}
+
+// Close closes the connection. Pending and later requests fail with
+// ErrNodeClosed, and subscription channels are closed.
+func (n *Node) Close() error {
+	n.stateLock.Lock()
+	if n.state == Closed {
+		n.stateLock.Unlock()
+		return nil
+	}
+	transport := n.transport
+	n.setStateLocked(Closed)
+	n.stateLock.Unlock()
+
+	if transport == nil {
+		n.closePush()
+		return nil
+	}
+	return transport.Close()
+}
+
+// misbehaved reports a misbehavior of the server to OnMisbehavior and
+// returns err.
+func (n *Node) misbehaved(kind Misbehavior, err error) error {
+	if n.OnMisbehavior != nil {
+		n.OnMisbehavior(kind, err)
+	}
+	return err
+}
+
+// shutdown closes the node after the connection was lost for good.
+func (n *Node) shutdown(err error) {
+	n.stateLock.Lock()
+	if n.state != Closed {
+		n.closeErr = err
+		n.setStateLocked(Closed)
+	}
+	n.stateLock.Unlock()
+}
+
+// setStateLocked changes the state and notifies StateChanges listeners.
+func (n *Node) setStateLocked(state ConnectionState) {
+	n.state = state
+	for _, c := range n.stateSubs {
 		select {
-		case err := <-n.transport.Errors():
-			n.err(err)
+		case c <- state:
+		default:
+		}
+	}
+	if state == Closed {
+		for _, c := range n.stateSubs {
+			close(c)
+		}
+		n.stateSubs = nil
+		close(n.done)
+	}
+}
+
+// listen processes messages from the server, reconnecting if enabled.
+func (n *Node) listen(transport Transport) {
+	defer n.closePush()
+	for {
+		err := n.receive(transport)
+		if err == nil {
 			return
-		case bytes := <-n.transport.Responses():
-			msg := &respMetadata{}
-			if err := json.Unmarshal(bytes, msg); err != nil {
-				n.err(err)
-				return
-			}
-			if len(msg.Error) > 0 {
-				n.err(fmt.Errorf("error from server: %#v", msg.Error))
-				return
+		}
+		if !n.Reconnect || n.dial == nil {
+			n.failPending()
+			log.Printf("ERR %s: %s", n.Address, err)
+			n.shutdown(err)
+			return
+		}
+		log.Printf("ERR %s: %s, reconnecting", n.Address, err)
+		if !n.disconnect() {
+			return
+		}
+		transport.Close()
+		if transport = n.redial(); transport == nil {
+			return
+		}
+	}
+}
+
+// receive dispatches the messages of transport until it fails, returning its
+// error, or the node is closed, returning nil.
+func (n *Node) receive(transport Transport) error {
+	for {
+		select {
+		case <-n.done:
+			return nil
+		case err := <-transport.Errors():
+			select {
+			case <-n.done:
+				return nil
+			default:
 			}
-			if len(msg.Method) > 0 {
-				n.pushHandlersLock.RLock()
-				handlers := n.pushHandlers[msg.Method]
-				n.pushHandlersLock.RUnlock()
-
-				for _, handler := range handlers {
-					select {
-					case handler <- bytes:
-					default:
-					}
+			return err
+		case bytes := <-transport.Responses():
+			// Answers to a batch arrive as one array.
+			if trimmed := strings.TrimSpace(string(bytes)); strings.HasPrefix(trimmed, "[") {
+				var msgs []json.RawMessage
+				if err := json.Unmarshal(bytes, &msgs); err != nil {
+					log.Printf("ERR %s: %s", n.Address, err)
+					continue
+				}
+				for _, msg := range msgs {
+					n.dispatch(msg)
 				}
+				continue
 			}
+			n.dispatch(bytes)
+		}
+	}
+}
 
-			n.handlersLock.RLock()
-			c, ok := n.handlers[msg.Id]
-			n.handlersLock.RUnlock()
+// dispatch hands a message to the push listeners of its method or the
+// request awaiting it.
+func (n *Node) dispatch(bytes []byte) {
+	msg := &respMetadata{}
+	if err := json.Unmarshal(bytes, msg); err != nil {
+		log.Printf("ERR %s: %s", n.Address, err)
+		return
+	}
+	if len(msg.Method) > 0 {
+		n.pushHandlersLock.RLock()
+		handlers := n.pushHandlers[msg.Method]
+		n.pushHandlersLock.RUnlock()
 
-			if ok {
-				c <- bytes
+		for _, handler := range handlers {
+			select {
+			case handler <- bytes:
+			default:
 			}
 		}
+		return
+	}
+
+	n.handlersLock.RLock()
+	c, ok := n.handlers[msg.Id]
+	n.handlersLock.RUnlock()
+
+	if ok {
+		c <- bytes
+	} else if msg.Error != nil {
+		log.Printf("unhandled %s", msg.Error)
 	}
 }
 
-// listenPush returns a channel of messages matching the method.
+// disconnect drops the lost transport and fails the pending requests. New
+// requests fail with ErrConnectionLost until the node is Connected again. It
+// returns false if the node was closed meanwhile.
+func (n *Node) disconnect() bool {
+	n.stateLock.Lock()
+	closed := n.state == Closed
+	if !closed {
+		n.transport = nil
+		n.setStateLocked(Reconnecting)
+	}
+	n.stateLock.Unlock()
+	// Requests sent before the transport was dropped are answered here.
+	n.failPending()
+	return !closed
+}
+
+// redial reconnects with backoff until it succeeds, returning the new
+// transport, or the node is closed, returning nil.
+func (n *Node) redial() Transport {
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-time.After(delay):
+		case <-n.done:
+			return nil
+		}
+		transport, err := n.dial()
+		if err != nil {
+			log.Printf("ERR %s: %s", n.Address, err)
+			if delay *= 2; delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			continue
+		}
+		n.stateLock.Lock()
+		if n.state == Closed {
+			n.stateLock.Unlock()
+			transport.Close()
+			return nil
+		}
+		n.transport = transport
+		n.setStateLocked(Connected)
+		n.stateLock.Unlock()
+		return transport
+	}
+}
+
+// failPending fails the requests awaiting an answer on a lost connection.
+func (n *Node) failPending() {
+	n.handlersLock.Lock()
+	defer n.handlersLock.Unlock()
+	for id, c := range n.handlers {
+		close(c)
+		delete(n.handlers, id)
+	}
+}
+
+// closePush closes the notification channels, ending subscriptions.
+func (n *Node) closePush() {
+	n.pushHandlersLock.Lock()
+	defer n.pushHandlersLock.Unlock()
+	if n.pushClosed {
+		return
+	}
+	n.pushClosed = true
+	for method, handlers := range n.pushHandlers {
+		for _, c := range handlers {
+			close(c)
+		}
+		delete(n.pushHandlers, method)
+	}
+}
+
+// pushBufferSize is the number of notifications buffered per listener before
+// new ones are dropped.
+const pushBufferSize = 16
+
+// listenPush returns a channel of messages matching the method. It's closed
+// once the node is closed.
 func (n *Node) listenPush(method string) <-chan []byte {
-	c := make(chan []byte, 1)
+	c := make(chan []byte, pushBufferSize)
 	n.pushHandlersLock.Lock()
 	defer n.pushHandlersLock.Unlock()
+	if n.pushClosed {
+		close(c)
+		return c
+	}
 	n.pushHandlers[method] = append(n.pushHandlers[method], c)
 	return c
 }
 
+// unlistenPush removes a channel returned by listenPush.
+func (n *Node) unlistenPush(method string, c <-chan []byte) {
+	n.pushHandlersLock.Lock()
+	defer n.pushHandlersLock.Unlock()
+	handlers := n.pushHandlers[method]
+	for i, handler := range handlers {
+		if handler == c {
+			n.pushHandlers[method] = append(handlers[:i:i], handlers[i+1:]...)
+			return
+		}
+	}
+}
+
 // request makes a request to the server and unmarshals the response into v.
-func (n *Node) request(method string, params []string, v interface{}) error {
-	msg := request{
-		Id:     n.nextId,
-		Method: method,
-		Params: params,
+// Params may be of any JSON encodable type. Requests wait for their turn if
+// the node is throttled by Limits.
+func (n *Node) request(method string, params []interface{}, v interface{}) error {
+	if !n.acquire(method) {
+		return ErrNodeClosed
 	}
-	n.nextId++
+	defer n.release()
+
+	msg, c := n.newRequest(method, params)
+	defer n.removeHandler(msg.Id)
 	bytes, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	bytes = append(bytes, delim)
-	if err := n.transport.SendMessage(bytes); err != nil {
+	if err := n.send(bytes); err != nil {
 		return err
 	}
+	return n.await(method, c, v)
+}
 
-	c := make(chan []byte, 1)
+// batchCall is one request of a batch. Result is unmarshalled into like the v
+// of request; Err is set if the call failed.
+type batchCall struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Err    error
+}
+
+// requestBatch sends calls to the server as one JSON-RPC batch and waits for
+// all of them. The batch takes a single turn of the limiter. The returned
+// error is set if the batch couldn't be sent.
+func (n *Node) requestBatch(calls []*batchCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	if !n.acquire(calls[0].Method) {
+		return ErrNodeClosed
+	}
+	defer n.release()
+
+	msgs := make([]request, len(calls))
+	handlers := make([]chan []byte, len(calls))
+	for i, call := range calls {
+		msgs[i], handlers[i] = n.newRequest(call.Method, call.Params)
+		defer n.removeHandler(msgs[i].Id)
+	}
+	bytes, err := json.Marshal(msgs)
+	if err != nil {
+		return err
+	}
+	if err := n.send(bytes); err != nil {
+		return err
+	}
+	for i, call := range calls {
+		call.Err = n.await(call.Method, handlers[i], call.Result)
+	}
+	return nil
+}
+
+// acquire waits for the limiter to let a request for method through, or
+// returns false if the node is closed first.
+func (n *Node) acquire(method string) bool {
+	n.limiterOnce.Do(func() {
+		n.limiter = newLimiter(n.Limits)
+	})
+	return n.limiter == nil || n.limiter.acquire(methodPriority(method), n.done)
+}
+
+// release frees the limiter slot of an answered request.
+func (n *Node) release() {
+	if n.limiter != nil {
+		n.limiter.release()
+	}
+}
 
+// newRequest allocates an id for a request and registers the channel its
+// answer is delivered to. The handler must be removed once done.
+func (n *Node) newRequest(method string, params []interface{}) (request, chan []byte) {
+	n.nextIdLock.Lock()
+	msg := request{
+		Id:     n.nextId,
+		Method: method,
+		Params: params,
+	}
+	n.nextId++
+	n.nextIdLock.Unlock()
+
+	if msg.Params == nil {
+		msg.Params = []interface{}{}
+	}
+	c := make(chan []byte, 1)
 	n.handlersLock.Lock()
 	n.handlers[msg.Id] = c
 	n.handlersLock.Unlock()
+	return msg, c
+}
 
-	resp := <-c
-
+func (n *Node) removeHandler(id int) {
 	n.handlersLock.Lock()
-	defer n.handlersLock.Unlock()
-	delete(n.handlers, msg.Id)
+	delete(n.handlers, id)
+	n.handlersLock.Unlock()
+}
+
+// send writes an encoded request to the transport.
+func (n *Node) send(bytes []byte) error {
+	n.stateLock.Lock()
+	transport, state := n.transport, n.state
+	n.stateLock.Unlock()
+	if transport == nil {
+		if state == Reconnecting {
+			return ErrConnectionLost
+		}
+		return ErrNodeClosed
+	}
+	return transport.SendMessage(append(bytes, delim))
+}
 
+// await waits for the answer to a request and unmarshals it into v.
+func (n *Node) await(method string, c chan []byte, v interface{}) error {
+	var resp []byte
+	select {
+	case bytes, ok := <-c:
+		if !ok {
+			return ErrConnectionLost
+		}
+		resp = bytes
+	case <-n.done:
+		return ErrNodeClosed
+	}
+
+	msgMeta := &respMetadata{}
+	if err := json.Unmarshal(resp, msgMeta); err != nil {
+		return &DecodeError{Method: method, Err: err}
+	}
+	if msgMeta.Error != nil {
+		return msgMeta.Error
+	}
 	if err := json.Unmarshal(resp, v); err != nil {
-		return nil
+		return &DecodeError{Method: method, Err: err}
 	}
 	return nil
 }
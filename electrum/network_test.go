@@ -0,0 +1,318 @@
+package electrum
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTransport is an in-memory Transport that answers requests using a map
+// of method handlers.
+type fakeTransport struct {
+	responses chan []byte
+	errors    chan error
+
+	mu       sync.Mutex
+	sent     []request
+	handlers map[string]func(params []interface{}) (interface{}, string)
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{
+		responses: make(chan []byte, 16),
+		errors:    make(chan error, 1),
+		handlers:  make(map[string]func([]interface{}) (interface{}, string)),
+	}
+}
+
+// handle registers a handler returning a result or an error message.
+func (t *fakeTransport) handle(method string, f func(params []interface{}) (interface{}, string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[method] = f
+}
+
+// push sends a server notification.
+func (t *fakeTransport) push(method string, params ...interface{}) {
+	bytes, _ := json.Marshal(map[string]interface{}{
+		"method": method,
+		"params": params,
+	})
+	t.responses <- append(bytes, delim)
+}
+
+func (t *fakeTransport) SendMessage(body []byte) error {
+	var answer interface{}
+	if strings.HasPrefix(string(body), "[") {
+		var reqs []request
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			return err
+		}
+		var answers []interface{}
+		for _, req := range reqs {
+			answers = append(answers, t.answer(req))
+		}
+		answer = answers
+	} else {
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			return err
+		}
+		answer = t.answer(req)
+	}
+	bytes, err := json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+	go func() { t.responses <- append(bytes, delim) }()
+	return nil
+}
+
+// answer records a request and returns the response of its handler.
+func (t *fakeTransport) answer(req request) map[string]interface{} {
+	t.mu.Lock()
+	t.sent = append(t.sent, req)
+	f, ok := t.handlers[req.Method]
+	t.mu.Unlock()
+
+	resp := map[string]interface{}{"id": req.Id}
+	if !ok {
+		resp["error"] = "unknown method " + req.Method
+	} else if result, errMsg := f(req.Params); errMsg != "" {
+		resp["error"] = errMsg
+	} else {
+		resp["result"] = result
+	}
+	return resp
+}
+
+func (t *fakeTransport) Responses() <-chan []byte {
+	return t.responses
+}
+
+func (t *fakeTransport) Errors() <-chan error {
+	return t.errors
+}
+
+func (t *fakeTransport) Close() error {
+	return nil
+}
+
+func newFakeNode() (*Node, *fakeTransport) {
+	t := newFakeTransport()
+	n := NewNode()
+	n.start(t)
+	return n, t
+}
+
+func TestRequestTypedParams(t *testing.T) {
+	n, ft := newFakeNode()
+	ft.handle("blockchain.estimatefee", func(params []interface{}) (interface{}, string) {
+		if len(params) != 1 || params[0] != float64(6) {
+			return nil, "bad params"
+		}
+		return 0.0001, ""
+	})
+	fee, err := n.BlockchainEstimateFee(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee != 0.0001 {
+		t.Fatalf("fee = %v", fee)
+	}
+}
+
+func TestRequestBatch(t *testing.T) {
+	n, ft := newFakeNode()
+	ft.handle("blockchain.estimatefee", func(params []interface{}) (interface{}, string) {
+		return params[0].(float64) / 1000, ""
+	})
+	fee := &struct {
+		Result float64 `json:"result"`
+	}{}
+	calls := []*batchCall{
+		{Method: "blockchain.estimatefee", Params: []interface{}{2}, Result: fee},
+		{Method: "server.banner", Result: &basicResp{}},
+	}
+	if err := n.requestBatch(calls); err != nil {
+		t.Fatal(err)
+	}
+	if calls[0].Err != nil || fee.Result != 0.002 {
+		t.Errorf("first call = %v, %v", fee.Result, calls[0].Err)
+	}
+	if calls[1].Err == nil {
+		t.Error("expected an error for the unknown method")
+	}
+}
+
+func TestRequestDecodeError(t *testing.T) {
+	n, ft := newFakeNode()
+	ft.handle("blockchain.estimatefee", func(params []interface{}) (interface{}, string) {
+		return "not a number", ""
+	})
+	if _, err := n.BlockchainEstimateFee(6); err == nil || !strings.Contains(err.Error(), "blockchain.estimatefee") {
+		t.Fatalf("expected decode error, got %v", err)
+	}
+}
+
+func TestNodeClose(t *testing.T) {
+	n, ft := newFakeNode()
+	answer := make(chan struct{})
+	ft.handle("server.banner", func([]interface{}) (interface{}, string) {
+		<-answer
+		return "banner", ""
+	})
+	ft.handle("blockchain.scripthash.subscribe", func([]interface{}) (interface{}, string) {
+		return "status", ""
+	})
+	statuses, err := n.BlockchainScripthashSubscribe("sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-statuses
+	states := n.StateChanges()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := n.ServerBanner()
+		errs <- err
+	}()
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(answer)
+	if err := <-errs; err != ErrNodeClosed {
+		t.Errorf("pending request: %v", err)
+	}
+	if _, err := n.ServerBanner(); err != ErrNodeClosed {
+		t.Errorf("request after close: %v", err)
+	}
+	<-n.Done()
+	if s := <-states; s != Closed {
+		t.Errorf("state = %s", s)
+	}
+	if _, ok := <-states; ok {
+		t.Errorf("state changes not closed")
+	}
+	if _, ok := <-statuses; ok {
+		t.Errorf("subscription not closed")
+	}
+	if n.Err() != nil {
+		t.Errorf("Err = %v", n.Err())
+	}
+}
+
+func TestNodeConnectionLost(t *testing.T) {
+	n, ft := newFakeNode()
+	ft.errors <- io.EOF
+	<-n.Done()
+	if n.State() != Closed || n.Err() != io.EOF {
+		t.Errorf("state %s, err %v", n.State(), n.Err())
+	}
+}
+
+func TestNodeReconnect(t *testing.T) {
+	defer func(d time.Duration) { minReconnectDelay = d }(minReconnectDelay)
+	minReconnectDelay = time.Millisecond
+
+	ft := newFakeTransport()
+	n := NewNode()
+	n.Reconnect = true
+	redialed := newFakeTransport()
+	redialed.handle("server.banner", func([]interface{}) (interface{}, string) {
+		return "redialed", ""
+	})
+	n.dial = func() (Transport, error) {
+		return redialed, nil
+	}
+	states := n.StateChanges()
+	if err := n.start(ft); err != nil {
+		t.Fatal(err)
+	}
+	ft.errors <- io.EOF
+	for _, want := range []ConnectionState{Connected, Reconnecting, Connected} {
+		if s := <-states; s != want {
+			t.Fatalf("state = %s; want %s", s, want)
+		}
+	}
+	if banner, err := n.ServerBanner(); err != nil || banner != "redialed" {
+		t.Errorf("banner = %q, %v", banner, err)
+	}
+	n.Close()
+	if s := <-states; s != Closed {
+		t.Errorf("state = %s", s)
+	}
+}
+
+func TestNodeRequestWhileReconnecting(t *testing.T) {
+	ft := newFakeTransport()
+	n := NewNode()
+	n.Reconnect = true
+	n.dial = func() (Transport, error) {
+		return nil, io.EOF
+	}
+	states := n.StateChanges()
+	if err := n.start(ft); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	ft.errors <- io.EOF
+	for _, want := range []ConnectionState{Connected, Reconnecting} {
+		if s := <-states; s != want {
+			t.Fatalf("state = %s; want %s", s, want)
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := n.ServerBanner()
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err != ErrConnectionLost {
+			t.Errorf("expected ErrConnectionLost, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request hung while reconnecting")
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if len(ft.sent) != 0 {
+		t.Errorf("request was sent to the lost transport: %+v", ft.sent)
+	}
+}
+
+// pipeDialer hands out one end of an in-memory connection.
+type pipeDialer struct {
+	conn net.Conn
+}
+
+func (d *pipeDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+func TestConnectSSLDialerDefaultsToTLS(t *testing.T) {
+	client, server := net.Pipe()
+	first := make(chan byte, 1)
+	go func() {
+		b := make([]byte, 1)
+		io.ReadFull(server, b)
+		first <- b[0]
+		server.Close()
+	}()
+
+	n := NewNode()
+	n.Dialer = &pipeDialer{conn: client}
+	if err := n.ConnectSSL("electrum.example:50002", nil); err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+	// 0x16 starts a TLS handshake record.
+	if b := <-first; b != 0x16 {
+		t.Errorf("first byte sent = %#x; want a TLS handshake", b)
+	}
+}
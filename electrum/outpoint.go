@@ -0,0 +1,253 @@
+package electrum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// SpendEvent describes the state of a subscribed outpoint.
+type SpendEvent struct {
+	OutPoint wire.OutPoint
+
+	// Funded is set once the transaction creating the outpoint is known, at
+	// Height (0 while in the mempool).
+	Funded bool
+	Height int
+
+	// Spent is set once a transaction spending the outpoint is known, at
+	// SpenderHeight (0 while in the mempool).
+	Spent         bool
+	SpenderTxID   chainhash.Hash
+	SpenderHeight int
+}
+
+// outpointStatus is the status sent by servers implementing
+// blockchain.outpoint.subscribe.
+type outpointStatus struct {
+	Height        *int   `json:"height"`
+	SpenderTxHash string `json:"spender_txhash"`
+	SpenderHeight int    `json:"spender_height"`
+}
+
+func (s *outpointStatus) event(op wire.OutPoint) (*SpendEvent, error) {
+	ev := &SpendEvent{OutPoint: op}
+	if s.Height != nil {
+		ev.Funded = true
+		ev.Height = *s.Height
+	}
+	if len(s.SpenderTxHash) > 0 {
+		hash, err := chainhash.NewHashFromStr(s.SpenderTxHash)
+		if err != nil {
+			return nil, err
+		}
+		ev.Spent = true
+		ev.SpenderTxID = *hash
+		ev.SpenderHeight = s.SpenderHeight
+	}
+	return ev, nil
+}
+
+// BlockchainOutpointSubscribe subscribes to the funding and spending of an
+// outpoint whose output has the script pkScript. Servers without
+// blockchain.outpoint.subscribe are emulated by subscribing to the script
+// hash and checking new transactions in its history.
+// https://electrum-protocol.readthedocs.io/en/latest/protocol-methods.html#blockchain-outpoint-subscribe
+func (n *Node) BlockchainOutpointSubscribe(op wire.OutPoint, pkScript []byte) (<-chan *SpendEvent, error) {
+	stop := make(chan struct{})
+	n.outpointSubsLock.Lock()
+	if _, ok := n.outpointSubs[op]; ok {
+		n.outpointSubsLock.Unlock()
+		return nil, ErrAlreadySubscribed
+	}
+	n.outpointSubs[op] = stop
+	n.outpointSubsLock.Unlock()
+
+	events, err := n.outpointSubscribe(op, pkScript, stop)
+	if isMethodNotFound(err) {
+		events, err = n.emulateOutpointSubscribe(op, pkScript, stop)
+	}
+	if err != nil {
+		n.outpointSubsLock.Lock()
+		delete(n.outpointSubs, op)
+		n.outpointSubsLock.Unlock()
+		return nil, err
+	}
+	return events, nil
+}
+
+// BlockchainOutpointUnsubscribe stops notifications for an outpoint.
+// https://electrum-protocol.readthedocs.io/en/latest/protocol-methods.html#blockchain-outpoint-unsubscribe
+func (n *Node) BlockchainOutpointUnsubscribe(op wire.OutPoint) error {
+	n.outpointSubsLock.Lock()
+	stop, ok := n.outpointSubs[op]
+	delete(n.outpointSubs, op)
+	n.outpointSubsLock.Unlock()
+	if !ok {
+		return nil
+	}
+	close(stop)
+	var resp struct {
+		Result bool `json:"result"`
+	}
+	err := n.request("blockchain.outpoint.unsubscribe", []interface{}{op.Hash.String(), op.Index}, &resp)
+	if isMethodNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// outpointSubscribe uses the server's blockchain.outpoint.subscribe.
+func (n *Node) outpointSubscribe(op wire.OutPoint, pkScript []byte, stop <-chan struct{}) (<-chan *SpendEvent, error) {
+	msgs := n.listenPush("blockchain.outpoint.subscribe")
+	resp := &struct {
+		Result *outpointStatus `json:"result"`
+	}{}
+	params := []interface{}{op.Hash.String(), op.Index, hex.EncodeToString(pkScript)}
+	if err := n.request("blockchain.outpoint.subscribe", params, resp); err != nil {
+		n.unlistenPush("blockchain.outpoint.subscribe", msgs)
+		return nil, err
+	}
+	if resp.Result == nil {
+		resp.Result = &outpointStatus{}
+	}
+	ev, err := resp.Result.event(op)
+	if err != nil {
+		n.unlistenPush("blockchain.outpoint.subscribe", msgs)
+		return nil, err
+	}
+	events := make(chan *SpendEvent, 1)
+	events <- ev
+	go func() {
+		defer n.unlistenPush("blockchain.outpoint.subscribe", msgs)
+		defer close(events)
+		for {
+			var msg []byte
+			var ok bool
+			select {
+			case <-stop:
+				return
+			case msg, ok = <-msgs:
+			}
+			if !ok {
+				return
+			}
+			resp := &struct {
+				Params []json.RawMessage `json:"params"`
+			}{}
+			if err := json.Unmarshal(msg, resp); err != nil {
+				log.Printf("ERR %s", err)
+				continue
+			}
+			if len(resp.Params) != 2 {
+				log.Printf("outpoint subscription params len != 2 %s", msg)
+				continue
+			}
+			var ref []interface{}
+			if err := json.Unmarshal(resp.Params[0], &ref); err != nil || len(ref) != 2 ||
+				ref[0] != op.Hash.String() || ref[1] != float64(op.Index) {
+				continue
+			}
+			status := &outpointStatus{}
+			if err := json.Unmarshal(resp.Params[1], status); err != nil {
+				log.Printf("ERR %s", err)
+				continue
+			}
+			ev, err := status.event(op)
+			if err != nil {
+				log.Printf("ERR %s", err)
+				continue
+			}
+			select {
+			case events <- ev:
+			case <-stop:
+				return
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// emulateOutpointSubscribe follows an outpoint by subscribing to the history
+// of its script hash and checking transactions not seen before.
+func (n *Node) emulateOutpointSubscribe(op wire.OutPoint, pkScript []byte, stop <-chan struct{}) (<-chan *SpendEvent, error) {
+	updates, err := n.scripthashSubscribeHistory(ScriptHash(pkScript), stop)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan *SpendEvent, 1)
+	go func() {
+		defer close(events)
+		// spends caches whether a transaction of the history spends op.
+		spends := make(map[chainhash.Hash]bool)
+		var last *SpendEvent
+		for {
+			var update *HistoryUpdate
+			select {
+			case <-stop:
+				return
+			case update = <-updates:
+			}
+			if update == nil {
+				return
+			}
+			if update.Err != nil {
+				log.Printf("ERR %s", update.Err)
+			}
+			ev := &SpendEvent{OutPoint: op}
+			for _, item := range update.History {
+				if item.TxID == op.Hash {
+					ev.Funded = true
+					ev.Height = item.Height
+					continue
+				}
+				spent, ok := spends[item.TxID]
+				if !ok {
+					var err error
+					if spent, err = n.spendsOutPoint(item.TxID.String(), item.Height, op); err != nil {
+						log.Printf("ERR %s", err)
+						continue
+					}
+					spends[item.TxID] = spent
+				}
+				if spent {
+					ev.Spent = true
+					ev.SpenderTxID = item.TxID
+					ev.SpenderHeight = item.Height
+				}
+			}
+			if last != nil && *last == *ev {
+				continue
+			}
+			last = ev
+			select {
+			case events <- ev:
+			case <-stop:
+				return
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// spendsOutPoint returns whether the transaction txid, confirmed at height or
+// 0, spends op.
+func (n *Node) spendsOutPoint(txid string, height int, op wire.OutPoint) (bool, error) {
+	tx, err := n.transaction(txid, height)
+	if err != nil {
+		return false, err
+	}
+	for _, in := range tx.TxIn {
+		if in.PreviousOutPoint == op {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,99 @@
+package electrum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func txHex(t *testing.T, tx *wire.MsgTx) string {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func nextSpendEvent(t *testing.T, events <-chan *SpendEvent) *SpendEvent {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for spend event")
+	}
+	return nil
+}
+
+func TestOutpointSubscribeEmulated(t *testing.T) {
+	pkScript := []byte{0x51}
+	funding := wire.NewMsgTx(1)
+	funding.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	funding.AddTxOut(wire.NewTxOut(1000, pkScript))
+	op := wire.OutPoint{Hash: funding.TxHash(), Index: 0}
+	spending := wire.NewMsgTx(1)
+	spending.AddTxIn(wire.NewTxIn(&op, nil, nil))
+	spending.AddTxOut(wire.NewTxOut(900, []byte{0x52}))
+
+	history := []*HistoryItem{{TxID: funding.TxHash(), State: Confirmed, Height: 100}}
+	n, ft := newFakeNode()
+	ft.handle("blockchain.scripthash.subscribe", func(params []interface{}) (interface{}, string) {
+		if params[0] != ScriptHash(pkScript) {
+			return nil, "bad scripthash"
+		}
+		return StatusHash(history), ""
+	})
+	ft.handle("blockchain.scripthash.get_history", func([]interface{}) (interface{}, string) {
+		return history, ""
+	})
+	ft.handle("blockchain.transaction.get", func(params []interface{}) (interface{}, string) {
+		if params[0] == spending.TxHash().String() {
+			return txHex(t, spending), ""
+		}
+		return txHex(t, funding), ""
+	})
+
+	events, err := n.BlockchainOutpointSubscribe(op, pkScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextSpendEvent(t, events); !ev.Funded || ev.Height != 100 || ev.Spent {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	history = append(history, &HistoryItem{TxID: spending.TxHash(), State: UnconfirmedParent})
+	ft.push("blockchain.scripthash.subscribe", ScriptHash(pkScript), StatusHash(history))
+	ev := nextSpendEvent(t, events)
+	if !ev.Spent || ev.SpenderTxID != spending.TxHash() || ev.SpenderHeight != 0 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	if err := n.BlockchainOutpointUnsubscribe(op); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("expected events to be closed")
+	}
+	// The emulation unsubscribes from the script hash.
+	for deadline := time.Now().Add(time.Second); ; {
+		ft.mu.Lock()
+		last := ft.sent[len(ft.sent)-1].Method
+		ft.mu.Unlock()
+		if last == "blockchain.scripthash.unsubscribe" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last request %s", last)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	n.pushHandlersLock.RLock()
+	listeners := len(n.pushHandlers["blockchain.scripthash.subscribe"])
+	n.pushHandlersLock.RUnlock()
+	if listeners != 0 {
+		t.Errorf("%d push listeners left", listeners)
+	}
+}
@@ -0,0 +1,310 @@
+package electrum
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Misbehavior is a kind of provably bad server behavior.
+type Misbehavior int
+
+const (
+	// InvalidHeader is sent headers failing validation.
+	InvalidHeader Misbehavior = iota
+	// ConsensusDisagreement is an answer disagreeing with a quorum of other
+	// servers.
+	ConsensusDisagreement
+	// WrongChain is serving a chain other than the expected network.
+	WrongChain
+	// InvalidProof is a merkle proof that doesn't verify.
+	InvalidProof
+)
+
+func (m Misbehavior) String() string {
+	switch m {
+	case InvalidHeader:
+		return "invalid header"
+	case ConsensusDisagreement:
+		return "consensus disagreement"
+	case WrongChain:
+		return "wrong chain"
+	case InvalidProof:
+		return "invalid proof"
+	}
+	return "unknown"
+}
+
+// Peer store defaults.
+const (
+	// DefaultBanDuration is the ban for a first misbehavior. It doubles with
+	// every further misbehavior up to MaxBanDuration.
+	DefaultBanDuration = time.Hour
+	MaxBanDuration     = 30 * 24 * time.Hour
+
+	// latencyWeight is the weight of a new sample in the latency average.
+	latencyWeight = 0.2
+	// staleAfter is how long since a peer was last reached before its score
+	// is halved.
+	staleAfter = 7 * 24 * time.Hour
+)
+
+// PeerRecord is what the store knows about a peer.
+type PeerRecord struct {
+	Peer      *Peer     `json:"peer"`
+	FirstSeen time.Time `json:"first_seen"`
+	// LastSeen is the last successful contact.
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+	Successes int       `json:"successes"`
+	Failures  int       `json:"failures"`
+	// Latency is a moving average of response times.
+	Latency time.Duration `json:"latency,omitempty"`
+	// TipLag is how many blocks the peer was behind when last checked.
+	TipLag      uint64    `json:"tip_lag,omitempty"`
+	Misbehavior int       `json:"misbehavior,omitempty"`
+	BannedUntil time.Time `json:"banned_until,omitempty"`
+	BanReason   string    `json:"ban_reason,omitempty"`
+}
+
+// Score rates the peer for selection, higher is better. It combines the
+// success rate, latency, tip lag, past misbehavior and how recently the peer
+// was reached. Banned peers score 0.
+func (r *PeerRecord) Score(now time.Time) float64 {
+	if now.Before(r.BannedUntil) {
+		return 0
+	}
+	score := float64(r.Successes+1) / float64(r.Successes+r.Failures+2)
+	score /= 1 + r.Latency.Seconds()
+	score /= 1 + float64(r.TipLag)
+	score /= 1 + float64(r.Misbehavior)
+	if r.LastSeen.IsZero() || now.Sub(r.LastSeen) > staleAfter {
+		score /= 2
+	}
+	return score
+}
+
+// PeerStore records the health of peers across runs.
+type PeerStore struct {
+	// BanDuration is the ban for a first misbehavior.
+	BanDuration time.Duration
+
+	path  string
+	mu    sync.Mutex
+	peers map[string]*PeerRecord
+	now   func() time.Time
+}
+
+// OpenPeerStore loads the store saved at path. An empty path keeps the store
+// in memory only.
+func OpenPeerStore(path string) (*PeerStore, error) {
+	s := &PeerStore{
+		BanDuration: DefaultBanDuration,
+		path:        path,
+		peers:       make(map[string]*PeerRecord),
+		now:         time.Now,
+	}
+	if len(path) == 0 {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var records []*PeerRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r.Peer != nil {
+			s.peers[peerKey(r.Peer.Host)] = r
+		}
+	}
+	return s, nil
+}
+
+// Save writes the store to its path.
+func (s *PeerStore) Save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.Records(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// peerKey identifies a peer by its lower case host, accepting host:port
+// addresses such as Node.Address.
+func peerKey(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return strings.ToLower(addr)
+}
+
+// Add records peers, updating the announced details of known ones.
+func (s *PeerStore) Add(peers ...*Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range peers {
+		key := peerKey(p.Host)
+		copied := *p
+		if r, ok := s.peers[key]; ok {
+			mergePeer(&copied, r.Peer)
+			r.Peer = &copied
+			continue
+		}
+		s.peers[key] = &PeerRecord{Peer: &copied, FirstSeen: s.now()}
+	}
+}
+
+// record calls f with the record of addr if it's known.
+func (s *PeerStore) record(addr string, f func(r *PeerRecord)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.peers[peerKey(addr)]; ok {
+		f(r)
+	}
+}
+
+// RecordSuccess records a successful request taking latency.
+func (s *PeerStore) RecordSuccess(addr string, latency time.Duration) {
+	s.record(addr, func(r *PeerRecord) {
+		r.Successes++
+		r.LastSeen = s.now()
+		if r.Latency == 0 {
+			r.Latency = latency
+		} else {
+			r.Latency += time.Duration(latencyWeight * float64(latency-r.Latency))
+		}
+	})
+}
+
+// RecordFailure records a failed connection or request.
+func (s *PeerStore) RecordFailure(addr string) {
+	s.record(addr, func(r *PeerRecord) {
+		r.Failures++
+	})
+}
+
+// RecordTipLag records how many blocks the peer is behind the best tip.
+func (s *PeerStore) RecordTipLag(addr string, lag uint64) {
+	s.record(addr, func(r *PeerRecord) {
+		r.TipLag = lag
+	})
+}
+
+// RecordMisbehavior bans the peer temporarily. Repeat offenders are banned
+// for longer.
+func (s *PeerStore) RecordMisbehavior(addr string, kind Misbehavior) {
+	s.record(addr, func(r *PeerRecord) {
+		r.Misbehavior++
+		ban := s.BanDuration
+		for i := 1; i < r.Misbehavior && ban < MaxBanDuration; i++ {
+			ban *= 2
+		}
+		if ban > MaxBanDuration {
+			ban = MaxBanDuration
+		}
+		r.BannedUntil = s.now().Add(ban)
+		r.BanReason = kind.String()
+	})
+}
+
+// RecordConsensus records the outcome of a consensus query: disagreeing
+// servers misbehaved and failed ones failed.
+func (s *PeerStore) RecordConsensus(report *ConsensusReport) {
+	for _, addr := range report.Disagreeing {
+		s.RecordMisbehavior(addr, ConsensusDisagreement)
+	}
+	for addr := range report.Failed {
+		s.RecordFailure(addr)
+	}
+}
+
+// AddNetworkMap records the peers of a crawl with their health. Peers on
+// the wrong chain are banned.
+func (s *PeerStore) AddNetworkMap(m *NetworkMap) {
+	for _, probe := range m.Peers {
+		s.Add(probe.Peer)
+		switch {
+		case !probe.Reachable:
+			s.RecordFailure(probe.Peer.Host)
+		case probe.Network != m.Network:
+			s.RecordMisbehavior(probe.Peer.Host, WrongChain)
+		default:
+			s.RecordSuccess(probe.Peer.Host, probe.Latency)
+			if probe.TipHeight < m.BestHeight {
+				s.RecordTipLag(probe.Peer.Host, m.BestHeight-probe.TipHeight)
+			} else {
+				s.RecordTipLag(probe.Peer.Host, 0)
+			}
+		}
+	}
+}
+
+// Banned returns whether the peer is currently banned.
+func (s *PeerStore) Banned(addr string) bool {
+	banned := false
+	s.record(addr, func(r *PeerRecord) {
+		banned = s.now().Before(r.BannedUntil)
+	})
+	return banned
+}
+
+// Records returns copies of all records.
+func (s *PeerStore) Records() []*PeerRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]*PeerRecord, 0, len(s.peers))
+	for _, r := range s.peers {
+		copied := *r
+		records = append(records, &copied)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return peerKey(records[i].Peer.Host) < peerKey(records[j].Peer.Host)
+	})
+	return records
+}
+
+// Best returns up to n peers that aren't banned, highest score first. A
+// non-positive n returns all of them.
+func (s *PeerStore) Best(n int) []*Peer {
+	now := s.now()
+	records := s.Records()
+	var candidates []*PeerRecord
+	for _, r := range records {
+		if !now.Before(r.BannedUntil) {
+			candidates = append(candidates, r)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score(now) > candidates[j].Score(now)
+	})
+	if n > 0 && len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	peers := make([]*Peer, len(candidates))
+	for i, r := range candidates {
+		peers[i] = r.Peer
+	}
+	return peers
+}
+
+// Discover returns the peers that aren't banned, best first.
+func (s *PeerStore) Discover(ctx context.Context) ([]*Peer, error) {
+	return s.Best(0), nil
+}
@@ -0,0 +1,65 @@
+package electrum
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPeerStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	s, err := OpenPeerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	s.Add(
+		&Peer{Host: "fast.example", SSLPort: "50002"},
+		&Peer{Host: "slow.example", SSLPort: "50002"},
+		&Peer{Host: "flaky.example", SSLPort: "50002"},
+		&Peer{Host: "liar.example", SSLPort: "50002"},
+	)
+	s.RecordSuccess("fast.example:50002", 50*time.Millisecond)
+	s.RecordSuccess("slow.example", 2*time.Second)
+	s.RecordSuccess("flaky.example", 50*time.Millisecond)
+	s.RecordFailure("flaky.example")
+	s.RecordFailure("flaky.example")
+	s.RecordSuccess("liar.example", 10*time.Millisecond)
+	s.RecordConsensus(&ConsensusReport{Disagreeing: []string{"liar.example:50002"}})
+
+	if !s.Banned("liar.example") {
+		t.Fatalf("liar should be banned")
+	}
+	best := s.Best(0)
+	if len(best) != 3 || best[0].Host != "fast.example" {
+		t.Fatalf("best = %+v", best)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := OpenPeerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.now = s.now
+	if records := loaded.Records(); len(records) != 4 {
+		t.Fatalf("loaded %d records", len(records))
+	}
+	if !loaded.Banned("liar.example") {
+		t.Errorf("ban not persisted")
+	}
+
+	// Bans expire and double for repeat offenders.
+	now = now.Add(DefaultBanDuration)
+	if s.Banned("liar.example") {
+		t.Errorf("ban should have expired")
+	}
+	s.RecordMisbehavior("liar.example", InvalidHeader)
+	now = now.Add(DefaultBanDuration + time.Minute)
+	if !s.Banned("liar.example") {
+		t.Errorf("second ban should last longer")
+	}
+}
@@ -0,0 +1,595 @@
+package electrum
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Pool defaults.
+const (
+	// DefaultPoolSize is the number of servers a pool keeps connected.
+	DefaultPoolSize = 3
+	// DefaultHedgeDelay is the hedge delay used until a server has enough
+	// latency samples.
+	DefaultHedgeDelay = time.Second
+
+	// minHedgeSamples is the number of samples needed to hedge on a
+	// server's percentile.
+	minHedgeSamples = 5
+)
+
+// Pool keeps connections to the best scoring servers of a peer store and
+// feeds the outcome of requests back into it. Healthy servers are preferred,
+// fastest first by their recent median latency.
+type Pool struct {
+	Network *Network
+	Store   *PeerStore
+	// Size is the number of servers kept connected.
+	Size int
+
+	// HedgePercentile enables hedging DoRead requests: if the fastest server
+	// hasn't answered within this percentile (e.g. 0.95) of its recent
+	// latencies, the request is also sent to the next server. Zero disables
+	// hedging.
+	HedgePercentile float64
+
+	// Retry is the retry policy of read requests, DefaultRetryPolicy if nil.
+	Retry *RetryPolicy
+	// BreakerThreshold is the number of consecutive failures after which a
+	// server isn't sent requests for BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// TLSConfig is used for SSL connections, verifying against the peer's
+	// host if nil.
+	TLSConfig *tls.Config
+	// Dialer optionally replaces the default dialer.
+	Dialer Dialer
+
+	// Limits throttles the requests sent to every server, unless overridden
+	// by ServerLimits for a host.
+	Limits       Limits
+	ServerLimits map[string]Limits
+
+	// connect connects to a peer, overridden in tests.
+	connect func(p *Peer) (*Node, error)
+
+	mu     sync.Mutex
+	nodes  []*poolNode
+	closed bool
+}
+
+// poolNode is a connected server of the pool. latency and breaker are
+// guarded by the pool's mutex.
+type poolNode struct {
+	node    *Node
+	peer    *Peer
+	latency latencyWindow
+	breaker breaker
+}
+
+// ServerStatus is the state of a connected server of a pool.
+type ServerStatus struct {
+	Peer    *Peer
+	Breaker BreakerState
+	// Failures is the number of consecutive failed requests.
+	Failures int
+	// OpenUntil is when an open circuit lets requests through again.
+	OpenUntil time.Time
+	// Latency is the median of recent response times, zero without samples.
+	Latency time.Duration
+	Score   float64
+}
+
+// NewPool creates a pool over the peers of store.
+func NewPool(net *Network, store *PeerStore) *Pool {
+	return &Pool{
+		Network:          net,
+		Store:            store,
+		Size:             DefaultPoolSize,
+		BreakerThreshold: DefaultBreakerThreshold,
+		BreakerCooldown:  DefaultBreakerCooldown,
+		Limits:           DefaultLimits,
+	}
+}
+
+// Nodes returns the connected servers, preferred first, connecting to the
+// best scoring peers of the store until the pool is full. Servers with an
+// open circuit are left out.
+func (p *Pool) Nodes(ctx context.Context) ([]*Node, error) {
+	size := p.Size
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	// Drop servers whose connection was lost for good.
+	open := p.nodes[:0]
+	for _, pn := range p.nodes {
+		if pn.node.State() == Closed {
+			p.Store.RecordFailure(pn.peer.Host)
+			continue
+		}
+		open = append(open, pn)
+	}
+	p.nodes = open
+	missing := size - len(p.nodes)
+	connected := make(map[string]bool)
+	for _, pn := range p.nodes {
+		connected[peerKey(pn.peer.Host)] = true
+	}
+	p.mu.Unlock()
+
+	// Dial without holding the lock so other requests aren't blocked.
+	var lastErr error
+	for _, peer := range p.Store.Best(0) {
+		if missing <= 0 {
+			break
+		}
+		if connected[peerKey(peer.Host)] {
+			continue
+		}
+		n, err := p.dial(ctx, peer)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			p.Store.RecordFailure(peer.Host)
+			lastErr = err
+			continue
+		}
+		if err := p.add(n, peer, size); err != nil {
+			return nil, err
+		}
+		connected[peerKey(peer.Host)] = true
+		missing--
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.nodes) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no usable servers for %s", p.Network.Name())
+		}
+		return nil, lastErr
+	}
+
+	now := p.Store.now()
+	var nodes []*Node
+	for _, pn := range p.sortedLocked() {
+		if pn.breaker.available(now) {
+			nodes = append(nodes, pn.node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, ErrCircuitOpen
+	}
+	return nodes, nil
+}
+
+// dial connects to a peer unless ctx is done first. A connection finishing
+// after that is closed.
+func (p *Pool) dial(ctx context.Context, peer *Peer) (*Node, error) {
+	type result struct {
+		n   *Node
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := p.connectPeer(peer)
+		done <- result{n, err}
+	}()
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.n != nil {
+				r.n.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// add inserts a freshly connected node, closing it instead if the pool was
+// closed, filled up or already has the server meanwhile.
+func (p *Pool) add(n *Node, peer *Peer, size int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		n.Close()
+		return ErrPoolClosed
+	}
+	for _, pn := range p.nodes {
+		if peerKey(pn.peer.Host) == peerKey(peer.Host) {
+			n.Close()
+			return nil
+		}
+	}
+	if len(p.nodes) >= size {
+		n.Close()
+		return nil
+	}
+	n.OnMisbehavior = func(kind Misbehavior, err error) {
+		log.Printf("ERR %s misbehaved: %s", peer.Host, err)
+		p.Misbehaved(n, kind)
+	}
+	p.nodes = append(p.nodes, &poolNode{node: n, peer: peer})
+	return nil
+}
+
+// Close disconnects every server of the pool. Later requests fail with
+// ErrPoolClosed.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, pn := range p.nodes {
+		pn.node.Close()
+	}
+	p.nodes = nil
+}
+
+// Status returns the state of the connected servers in order of preference.
+func (p *Pool) Status() []*ServerStatus {
+	records := make(map[string]*PeerRecord)
+	for _, r := range p.Store.Records() {
+		records[peerKey(r.Peer.Host)] = r
+	}
+	now := p.Store.now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var status []*ServerStatus
+	for _, pn := range p.sortedLocked() {
+		s := &ServerStatus{
+			Peer:      pn.peer,
+			Breaker:   pn.breaker.state(now),
+			Failures:  pn.breaker.failures,
+			OpenUntil: pn.breaker.openUntil,
+		}
+		s.Latency, _ = pn.latency.percentile(0.5)
+		if r, ok := records[peerKey(pn.peer.Host)]; ok {
+			s.Score = r.Score(now)
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// Node returns the preferred connected server.
+func (p *Pool) Node(ctx context.Context) (*Node, error) {
+	nodes, err := p.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+// Do runs f on the preferred server, recording its outcome and latency. f is
+// never retried, so Do suits requests such as broadcasts that mustn't be sent
+// twice.
+func (p *Pool) Do(ctx context.Context, f func(n *Node) error) error {
+	n, err := p.Node(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = p.run(ctx, n, func(n *Node) (interface{}, error) {
+		return nil, f(n)
+	})
+	return err
+}
+
+// DoRead runs the read-only request f on the preferred server and returns its
+// result. With hedging enabled, f is also run on the next server if the first
+// one is slow or fails, and the first successful result is returned. Transport
+// failures are retried according to the pool's retry policy, preferring
+// servers that weren't tried yet. f must be safe to run on several servers at
+// once.
+func (p *Pool) DoRead(ctx context.Context, f func(n *Node) (interface{}, error)) (interface{}, error) {
+	retry := p.Retry
+	if retry == nil {
+		retry = DefaultRetryPolicy
+	}
+	tried := make(map[*Node]bool)
+	var result interface{}
+	err := retry.do(ctx, func() error {
+		nodes, err := p.Nodes(ctx)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return !tried[nodes[i]] && tried[nodes[j]]
+		})
+		result, err = p.read(ctx, nodes, f, tried)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Call sends a request for method to the preferred server and unmarshals the
+// result into v. Idempotent methods are read with DoRead, anything else is
+// sent once with Do.
+func (p *Pool) Call(ctx context.Context, method string, params []interface{}, v interface{}) error {
+	if !IsIdempotent(method) {
+		resp := &struct {
+			Result interface{} `json:"result"`
+		}{v}
+		return p.Do(ctx, func(n *Node) error {
+			return n.request(method, params, resp)
+		})
+	}
+	result, err := p.DoRead(ctx, func(n *Node) (interface{}, error) {
+		resp := &struct {
+			Result json.RawMessage `json:"result"`
+		}{}
+		if err := n.request(method, params, resp); err != nil {
+			return nil, err
+		}
+		return resp.Result, nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(result.(json.RawMessage), v)
+}
+
+// read runs f on nodes[0], hedging to nodes[1] if enabled. The servers used
+// are added to tried.
+func (p *Pool) read(ctx context.Context, nodes []*Node, f func(n *Node) (interface{}, error), tried map[*Node]bool) (interface{}, error) {
+	if p.HedgePercentile <= 0 || len(nodes) < 2 {
+		tried[nodes[0]] = true
+		return p.run(ctx, nodes[0], f)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type answer struct {
+		result interface{}
+		err    error
+	}
+	answers := make(chan answer, 2)
+	start := func(n *Node) {
+		tried[n] = true
+		go func() {
+			result, err := p.run(ctx, n, f)
+			answers <- answer{result, err}
+		}()
+	}
+	start(nodes[0])
+	running, received := 1, 0
+	hedge := time.NewTimer(p.hedgeDelay(nodes[0]))
+	defer hedge.Stop()
+	var firstErr error
+	for {
+		select {
+		case <-hedge.C:
+			if running < 2 {
+				start(nodes[1])
+				running++
+			}
+		case a := <-answers:
+			if a.err == nil {
+				return a.result, nil
+			}
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			received++
+			if running < 2 {
+				start(nodes[1])
+				running++
+			} else if received == running {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// Ping pings every connected server to refresh its latency.
+func (p *Pool) Ping(ctx context.Context) error {
+	nodes, err := p.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			p.run(ctx, n, func(n *Node) (interface{}, error) {
+				return nil, n.ServerPing()
+			})
+		}(n)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// run runs f on n, recording the outcome and latency. It fails with
+// ErrCircuitOpen if n's circuit is open or its half-open trial is running.
+func (p *Pool) run(ctx context.Context, n *Node, f func(n *Node) (interface{}, error)) (interface{}, error) {
+	p.mu.Lock()
+	pn := p.lookupLocked(n)
+	allowed := pn == nil || pn.breaker.allow(p.Store.now())
+	p.mu.Unlock()
+	if !allowed {
+		return nil, ErrCircuitOpen
+	}
+
+	var result interface{}
+	start := time.Now()
+	err := withContext(ctx, func() error {
+		var err error
+		result, err = f(n)
+		return err
+	})
+	latency := time.Since(start)
+	if err != nil {
+		if isTransient(err) && ctx.Err() == nil {
+			p.failed(n)
+		} else {
+			p.mu.Lock()
+			if pn := p.lookupLocked(n); pn != nil {
+				pn.breaker.done()
+			}
+			p.mu.Unlock()
+		}
+		return nil, err
+	}
+	p.mu.Lock()
+	pn = p.lookupLocked(n)
+	if pn != nil {
+		pn.latency.add(latency)
+		pn.breaker.success()
+	}
+	p.mu.Unlock()
+	if pn != nil {
+		p.Store.RecordSuccess(pn.peer.Host, latency)
+	}
+	return result, nil
+}
+
+// hedgeDelay returns how long to wait for n before hedging.
+func (p *Pool) hedgeDelay(n *Node) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pn := p.lookupLocked(n)
+	if pn == nil || pn.latency.len() < minHedgeSamples {
+		return DefaultHedgeDelay
+	}
+	delay, _ := pn.latency.percentile(p.HedgePercentile)
+	return delay
+}
+
+// Misbehaved bans a server of the pool in the store and drops it.
+func (p *Pool) Misbehaved(n *Node, kind Misbehavior) {
+	if pn := p.lookup(n); pn != nil {
+		p.Store.RecordMisbehavior(pn.peer.Host, kind)
+		p.remove(n)
+	}
+}
+
+// failed records a failed request and counts it towards opening the
+// server's circuit. Server errors are answers, so callers only count
+// transport failures against the server.
+func (p *Pool) failed(n *Node) {
+	threshold := p.BreakerThreshold
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	cooldown := p.BreakerCooldown
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	p.mu.Lock()
+	pn := p.lookupLocked(n)
+	if pn != nil {
+		pn.breaker.failure(p.Store.now(), threshold, cooldown)
+	}
+	p.mu.Unlock()
+	if pn != nil {
+		p.Store.RecordFailure(pn.peer.Host)
+	}
+}
+
+// lookup returns the pool entry of a node.
+func (p *Pool) lookup(n *Node) *poolNode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lookupLocked(n)
+}
+
+func (p *Pool) lookupLocked(n *Node) *poolNode {
+	for _, pn := range p.nodes {
+		if pn.node == n {
+			return pn
+		}
+	}
+	return nil
+}
+
+// remove drops a node from the pool and disconnects it.
+func (p *Pool) remove(n *Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, pn := range p.nodes {
+		if pn.node == n {
+			p.nodes = append(p.nodes[:i], p.nodes[i+1:]...)
+			n.Close()
+			return
+		}
+	}
+}
+
+// sortedLocked returns the pool's nodes in order of preference: healthy
+// servers first, then by median latency, falling back to the store's average
+// for servers without samples.
+func (p *Pool) sortedLocked() []*poolNode {
+	records := make(map[string]*PeerRecord)
+	for _, r := range p.Store.Records() {
+		records[peerKey(r.Peer.Host)] = r
+	}
+	now := p.Store.now()
+	healthy := func(pn *poolNode) bool {
+		r, ok := records[peerKey(pn.peer.Host)]
+		return ok && r.Score(now) > 0 && r.TipLag <= crawlMaxLag
+	}
+	latency := func(pn *poolNode) time.Duration {
+		if median, ok := pn.latency.percentile(0.5); ok {
+			return median
+		}
+		if r, ok := records[peerKey(pn.peer.Host)]; ok && r.Latency > 0 {
+			return r.Latency
+		}
+		return DefaultHedgeDelay
+	}
+	sorted := append([]*poolNode(nil), p.nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if healthy(a) != healthy(b) {
+			return healthy(a)
+		}
+		return latency(a) < latency(b)
+	})
+	return sorted
+}
+
+// limits returns the limits of a peer's server.
+func (p *Pool) limits(peer *Peer) Limits {
+	for host, limits := range p.ServerLimits {
+		if peerKey(host) == peerKey(peer.Host) {
+			return limits
+		}
+	}
+	return p.Limits
+}
+
+// connectPeer connects to a peer with the pool's settings.
+func (p *Pool) connectPeer(peer *Peer) (*Node, error) {
+	if p.connect != nil {
+		return p.connect(peer)
+	}
+	n := NewNode()
+	n.Network = p.Network
+	n.Dialer = p.Dialer
+	n.Limits = p.limits(peer)
+	if err := n.ConnectPeer(peer, p.TLSConfig); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
@@ -0,0 +1,344 @@
+package electrum
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+func TestPool(t *testing.T) {
+	s, err := OpenPeerStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(&Peer{Host: "a.example", TCPPort: "50001"}, &Peer{Host: "b.example", TCPPort: "50001"}, &Peer{Host: "down.example", TCPPort: "50001"})
+	s.RecordSuccess("b.example", time.Millisecond)
+	s.RecordSuccess("a.example", time.Second)
+
+	pool := NewPool(MainNet, s)
+	pool.Size = 2
+	hosts := make(map[*Node]string)
+	pool.connect = func(p *Peer) (*Node, error) {
+		if p.Host == "down.example" {
+			return nil, errors.New("connection refused")
+		}
+		n, ft := newFakeNode()
+		ft.handle("server.ping", func([]interface{}) (interface{}, string) {
+			return nil, ""
+		})
+		hosts[n] = p.Host
+		return n, nil
+	}
+
+	ctx := context.Background()
+	n, err := pool.Node(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts[n] != "b.example" {
+		t.Errorf("best node is %s", hosts[n])
+	}
+	if err := pool.Do(ctx, func(n *Node) error { return n.ServerPing() }); err != nil {
+		t.Fatal(err)
+	}
+
+	pool.Misbehaved(n, InvalidHeader)
+	if !s.Banned("b.example") {
+		t.Errorf("b should be banned")
+	}
+	nodes, err := pool.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || hosts[nodes[0]] != "a.example" {
+		t.Fatalf("nodes after ban = %d", len(nodes))
+	}
+
+	// A merkle proof that doesn't match the stored header bans the server.
+	a := nodes[0]
+	a.Headers = NewHeaderStore(10)
+	a.Headers.Put(&BlockchainHeader{BlockHeight: 5, MerkleRoot: chainhash.Hash{}.String()})
+	if err := a.VerifyMerkle(chainhash.Hash{1}.String(), nil, 0, 5); err == nil {
+		t.Fatal("expected the proof to fail")
+	}
+	if !s.Banned("a.example") {
+		t.Errorf("a should be banned")
+	}
+	if a.State() != Closed {
+		t.Errorf("a should be dropped from the pool")
+	}
+}
+
+func TestPoolHedging(t *testing.T) {
+	s, err := OpenPeerStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(&Peer{Host: "slow.example", TCPPort: "50001"}, &Peer{Host: "fast.example", TCPPort: "50001"})
+	s.RecordSuccess("slow.example", time.Millisecond)
+	s.RecordSuccess("fast.example", 2*time.Millisecond)
+
+	pool := NewPool(MainNet, s)
+	pool.Size = 2
+	pool.HedgePercentile = 0.9
+	delays := map[string]time.Duration{"slow.example": 2 * time.Second}
+	pool.connect = func(p *Peer) (*Node, error) {
+		n, ft := newFakeNode()
+		delay := delays[p.Host]
+		host := p.Host
+		ft.handle("server.banner", func([]interface{}) (interface{}, string) {
+			time.Sleep(delay)
+			return host, ""
+		})
+		return n, nil
+	}
+
+	ctx := context.Background()
+	nodes, err := pool.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pretend slow.example usually answers within 10ms.
+	pool.mu.Lock()
+	for i := 0; i < minHedgeSamples; i++ {
+		pool.lookupLocked(nodes[0]).latency.add(10 * time.Millisecond)
+	}
+	pool.mu.Unlock()
+
+	start := time.Now()
+	result, err := pool.DoRead(ctx, func(n *Node) (interface{}, error) {
+		return n.ServerBanner()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "fast.example" {
+		t.Errorf("answered by %v", result)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged request took %s", elapsed)
+	}
+
+	// fast.example now has the lowest median latency.
+	if n, _ := pool.Node(ctx); n != nodes[1] {
+		t.Errorf("pool doesn't prefer the faster server")
+	}
+}
+
+func TestPoolBreaker(t *testing.T) {
+	s, err := OpenPeerStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	s.Add(&Peer{Host: "broken.example", TCPPort: "50001"}, &Peer{Host: "ok.example", TCPPort: "50001"})
+	s.RecordSuccess("broken.example", time.Millisecond)
+	s.RecordSuccess("ok.example", time.Second)
+
+	pool := NewPool(MainNet, s)
+	pool.Size = 2
+	pool.BreakerThreshold = 1
+	pool.Retry = &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	hosts := make(map[*Node]string)
+	pool.connect = func(p *Peer) (*Node, error) {
+		n, ft := newFakeNode()
+		host := p.Host
+		ft.handle("server.banner", func([]interface{}) (interface{}, string) {
+			return host, ""
+		})
+		hosts[n] = p.Host
+		return n, nil
+	}
+	read := func(n *Node) (interface{}, error) {
+		if hosts[n] == "broken.example" {
+			return nil, errors.New("connection reset by peer")
+		}
+		return n.ServerBanner()
+	}
+
+	ctx := context.Background()
+	result, err := pool.DoRead(ctx, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "ok.example" {
+		t.Errorf("answered by %v", result)
+	}
+	status := pool.Status()
+	if len(status) != 2 {
+		t.Fatalf("status = %+v", status)
+	}
+	for _, st := range status {
+		want := BreakerClosed
+		if st.Peer.Host == "broken.example" {
+			want = BreakerOpen
+		}
+		if st.Breaker != want {
+			t.Errorf("%s: breaker %s, want %s", st.Peer.Host, st.Breaker, want)
+		}
+	}
+	nodes, err := pool.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || hosts[nodes[0]] != "ok.example" {
+		t.Errorf("open circuit not skipped")
+	}
+	var banner string
+	if err := pool.Call(ctx, "server.banner", nil, &banner); err != nil {
+		t.Fatal(err)
+	}
+	if banner != "ok.example" {
+		t.Errorf("banner = %q", banner)
+	}
+
+	// Server errors are answers and never retried.
+	var calls int
+	_, err = pool.DoRead(ctx, func(n *Node) (interface{}, error) {
+		calls++
+		return nil, &ServerError{Message: "unknown method"}
+	})
+	if _, ok := err.(*ServerError); !ok || calls != 1 {
+		t.Errorf("server error: %v after %d calls", err, calls)
+	}
+
+	// A half-open circuit lets a single trial through, closing on success.
+	now = now.Add(DefaultBreakerCooldown)
+	if nodes, _ = pool.Nodes(ctx); len(nodes) != 2 {
+		t.Fatalf("half-open circuit skipped")
+	}
+	var broken *Node
+	for n, host := range hosts {
+		if host == "broken.example" {
+			broken = n
+		}
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	trial := make(chan error, 1)
+	go func() {
+		_, err := pool.run(ctx, broken, func(n *Node) (interface{}, error) {
+			close(started)
+			<-release
+			return n.ServerBanner()
+		})
+		trial <- err
+	}()
+	<-started
+	if _, err := pool.run(ctx, broken, read); err != ErrCircuitOpen {
+		t.Errorf("second request during the trial: %v", err)
+	}
+	if nodes, _ = pool.Nodes(ctx); len(nodes) != 1 || nodes[0] == broken {
+		t.Errorf("server with a running trial not skipped")
+	}
+	close(release)
+	if err := <-trial; err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range pool.Status() {
+		if st.Breaker != BreakerClosed || st.Failures != 0 {
+			t.Errorf("%s: breaker after trial = %s", st.Peer.Host, st.Breaker)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	r := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, Multiplier: 2}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if got := r.backoff(attempt + 1); got != want {
+			t.Errorf("backoff(%d) = %s; want %s", attempt+1, got, want)
+		}
+	}
+	if IsIdempotent("blockchain.transaction.broadcast") {
+		t.Errorf("broadcasts must not be retried")
+	}
+	if IsIdempotent("blockchain.scripthash.subscribe") {
+		t.Errorf("subscriptions must not be retried")
+	}
+	for _, err := range []error{ErrPoolClosed, &DecodeError{Method: "server.banner", Err: errors.New("bad json")}} {
+		if isTransient(err) {
+			t.Errorf("%v shouldn't be retried", err)
+		}
+	}
+	if !isTransient(ErrConnectionLost) {
+		t.Errorf("lost connections should be retried")
+	}
+
+	n, ft := newFakeNode()
+	ft.handle("server.banner", func([]interface{}) (interface{}, string) {
+		return 1, ""
+	})
+	if _, err := n.ServerBanner(); err == nil || isTransient(err) {
+		t.Errorf("undecodable response: %v", err)
+	}
+}
+
+func TestPoolSlowDial(t *testing.T) {
+	s, err := OpenPeerStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(&Peer{Host: "slow.example", TCPPort: "50001"})
+
+	pool := NewPool(MainNet, s)
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	late := make(chan *Node, 1)
+	pool.connect = func(p *Peer) (*Node, error) {
+		close(dialing)
+		<-release
+		n, _ := newFakeNode()
+		late <- n
+		return n, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := pool.Nodes(ctx)
+		errs <- err
+	}()
+	<-dialing
+	// The pool isn't locked while dialing.
+	pool.Status()
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	close(release)
+	select {
+	case <-(<-late).Done():
+	case <-time.After(time.Second):
+		t.Fatal("node connected after the context was done wasn't closed")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	s, err := OpenPeerStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(&Peer{Host: "a.example", TCPPort: "50001"})
+
+	pool := NewPool(MainNet, s)
+	pool.connect = func(p *Peer) (*Node, error) {
+		n, _ := newFakeNode()
+		return n, nil
+	}
+	ctx := context.Background()
+	n, err := pool.Node(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Close()
+	if n.State() != Closed {
+		t.Errorf("node state after Close = %s", n.State())
+	}
+	if _, err := pool.Nodes(ctx); err != ErrPoolClosed {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+}
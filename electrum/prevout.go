@@ -0,0 +1,150 @@
+package electrum
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// maxRBFSequence is the highest input sequence number signaling BIP 125
+// replaceability.
+const maxRBFSequence = wire.MaxTxInSequenceNum - 2
+
+// maxPrevoutBatch is the number of parents ResolvePrevouts fetches in one
+// batch.
+const maxPrevoutBatch = 50
+
+// Prevout is an output spent by a transaction input.
+type Prevout struct {
+	OutPoint wire.OutPoint
+	Value    btcutil.Amount
+	PkScript []byte
+	// Address is nil for scripts without a single address.
+	Address btcutil.Address
+}
+
+// ResolvedTx is a transaction with the outputs its inputs spend.
+type ResolvedTx struct {
+	Tx       *wire.MsgTx
+	Prevouts []*Prevout
+
+	InputValue  btcutil.Amount
+	OutputValue btcutil.Amount
+	Fee         btcutil.Amount
+	VSize       int64
+	// FeeRate is the fee in satoshis per virtual byte.
+	FeeRate float64
+	// RBF is set if the transaction signals BIP 125 replaceability.
+	RBF bool
+}
+
+// ResolvePrevouts fetches the parents of a transaction and computes its fee.
+// Parents already in the node's cache aren't fetched again, the others are
+// fetched in batches of up to maxPrevoutBatch.
+func (n *Node) ResolvePrevouts(tx *wire.MsgTx) (*ResolvedTx, error) {
+	if blockchain.IsCoinBaseTx(tx) {
+		return nil, ErrCoinbase
+	}
+
+	parents := make(map[chainhash.Hash]*wire.MsgTx)
+	var missing []string
+	for _, in := range tx.TxIn {
+		hash := in.PreviousOutPoint.Hash
+		if _, ok := parents[hash]; ok {
+			continue
+		}
+		parents[hash] = nil
+		var txHex string
+		if !n.cacheGet("tx:"+hash.String(), &txHex) {
+			missing = append(missing, hash.String())
+			continue
+		}
+		parent, err := decodeTx(txHex)
+		if err != nil {
+			return nil, err
+		}
+		parents[hash] = parent
+	}
+	for len(missing) > 0 {
+		batch := missing
+		if len(batch) > maxPrevoutBatch {
+			batch = batch[:maxPrevoutBatch]
+		}
+		missing = missing[len(batch):]
+		fetched, err := n.transactions(batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range fetched {
+			parents[parent.TxHash()] = parent
+		}
+	}
+
+	r := &ResolvedTx{Tx: tx}
+	for _, in := range tx.TxIn {
+		op := in.PreviousOutPoint
+		parent := parents[op.Hash]
+		if int(op.Index) >= len(parent.TxOut) {
+			return nil, fmt.Errorf("prevout %s doesn't exist", op)
+		}
+		out := parent.TxOut[op.Index]
+		prevout := &Prevout{
+			OutPoint: op,
+			Value:    btcutil.Amount(out.Value),
+			PkScript: out.PkScript,
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(out.PkScript, n.Network.Params)
+		if err == nil && len(addrs) == 1 {
+			prevout.Address = addrs[0]
+		}
+		r.Prevouts = append(r.Prevouts, prevout)
+		r.InputValue += prevout.Value
+		if in.Sequence <= maxRBFSequence {
+			r.RBF = true
+		}
+	}
+	for _, out := range tx.TxOut {
+		r.OutputValue += btcutil.Amount(out.Value)
+	}
+	r.Fee = r.InputValue - r.OutputValue
+	weight := int64(tx.SerializeSizeStripped()*(blockchain.WitnessScaleFactor-1) + tx.SerializeSize())
+	r.VSize = (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+	r.FeeRate = float64(r.Fee) / float64(r.VSize)
+	return r, nil
+}
+
+// transactions fetches, verifies and caches transactions in one batch.
+func (n *Node) transactions(txids []string) ([]*wire.MsgTx, error) {
+	calls := make([]*batchCall, len(txids))
+	for i, txid := range txids {
+		calls[i] = &batchCall{
+			Method: "blockchain.transaction.get",
+			Params: []interface{}{txid},
+			Result: &basicResp{},
+		}
+	}
+	if err := n.requestBatch(calls); err != nil {
+		return nil, err
+	}
+	txs := make([]*wire.MsgTx, len(txids))
+	for i, call := range calls {
+		if call.Err != nil {
+			return nil, call.Err
+		}
+		txHex := call.Result.(*basicResp).Result
+		if err := checkTxID(txids[i], txHex); err != nil {
+			return nil, err
+		}
+		n.cachePut("tx:"+txids[i], txHex, 0)
+		tx, err := decodeTx(txHex)
+		if err != nil {
+			return nil, err
+		}
+		txs[i] = tx
+	}
+	return txs, nil
+}
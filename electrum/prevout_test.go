@@ -0,0 +1,81 @@
+package electrum
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestResolvePrevouts(t *testing.T) {
+	addr, err := MainNet.DecodeAddress("1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := wire.NewMsgTx(1)
+	parent.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	parent.AddTxOut(wire.NewTxOut(30000, pkScript))
+	parent.AddTxOut(wire.NewTxOut(20000, []byte{txscript.OP_TRUE}))
+	parentHash := parent.TxHash()
+
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parentHash, 0), nil, nil))
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parentHash, 1), nil, nil))
+	tx.TxIn[1].Sequence = 0xfffffffd
+	tx.AddTxOut(wire.NewTxOut(45000, pkScript))
+
+	n, ft := newFakeNode()
+	n.Cache = NewCache(1<<20, nil)
+	calls := 0
+	ft.handle("blockchain.transaction.get", func(params []interface{}) (interface{}, string) {
+		calls++
+		return txHex(t, parent), ""
+	})
+	r, err := n.ResolvePrevouts(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.ResolvePrevouts(tx); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected parent to be fetched once, got %d", calls)
+	}
+	if r.Fee != 5000 || r.InputValue != 50000 || r.OutputValue != 45000 {
+		t.Errorf("unexpected amounts %+v", r)
+	}
+	if r.VSize != int64(tx.SerializeSize()) || r.FeeRate != 5000/float64(r.VSize) {
+		t.Errorf("VSize = %d, FeeRate = %f", r.VSize, r.FeeRate)
+	}
+	if !r.RBF {
+		t.Error("expected RBF signaling")
+	}
+	if r.Prevouts[0].Address == nil || r.Prevouts[0].Address.String() != addr.String() || r.Prevouts[1].Address != nil {
+		t.Errorf("unexpected prevout addresses %+v %+v", r.Prevouts[0], r.Prevouts[1])
+	}
+}
+
+func TestResolvePrevoutsWrongParent(t *testing.T) {
+	parent := wire.NewMsgTx(1)
+	parent.AddTxOut(wire.NewTxOut(30000, []byte{txscript.OP_TRUE}))
+	other := wire.NewMsgTx(1)
+	other.AddTxOut(wire.NewTxOut(90000, []byte{txscript.OP_TRUE}))
+	parentHash := parent.TxHash()
+
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parentHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(25000, []byte{txscript.OP_TRUE}))
+
+	n, ft := newFakeNode()
+	ft.handle("blockchain.transaction.get", func(params []interface{}) (interface{}, string) {
+		return txHex(t, other), ""
+	})
+	if _, err := n.ResolvePrevouts(tx); err == nil {
+		t.Fatal("expected an error for a parent not matching its hash")
+	}
+}
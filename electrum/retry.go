@@ -0,0 +1,101 @@
+package electrum
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy decides how failed read requests are retried. Only transport
+// failures are retried; server errors are answers.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It's multiplied by
+	// Multiplier for every further retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryPolicy is used by pools without a retry policy.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+}
+
+// NoRetry disables retries.
+var NoRetry = &RetryPolicy{MaxAttempts: 1}
+
+// backoff returns the wait before retry number attempt, starting at 1.
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := r.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		backoff = time.Duration(float64(backoff) * r.Multiplier)
+		if r.MaxBackoff > 0 && backoff >= r.MaxBackoff {
+			return r.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+// do runs f until it succeeds, fails permanently or runs out of attempts.
+func (r *RetryPolicy) do(ctx context.Context, f func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = f(); err == nil || !isTransient(err) || attempt >= r.MaxAttempts {
+			return err
+		}
+		select {
+		case <-time.After(r.backoff(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// isTransient returns whether err may go away by retrying: anything but an
+// answer from the server, an undecodable one, a closed pool or a cancelled
+// context.
+func isTransient(err error) bool {
+	switch err.(type) {
+	case *ServerError, *BroadcastError, *DecodeError:
+		return false
+	}
+	return err != ErrPoolClosed && err != context.Canceled && err != context.DeadlineExceeded
+}
+
+// idempotentMethods are the read methods that can safely be sent again.
+// Subscriptions aren't: a resent subscribe registers a second notification
+// stream on another server.
+var idempotentMethods = map[string]bool{
+	"blockchain.address.get_balance":     true,
+	"blockchain.address.get_history":     true,
+	"blockchain.address.get_mempool":     true,
+	"blockchain.address.listunspent":     true,
+	"blockchain.block.get_header":        true,
+	"blockchain.block.header":            true,
+	"blockchain.block.headers":           true,
+	"blockchain.estimatefee":             true,
+	"blockchain.relayfee":                true,
+	"blockchain.scripthash.get_balance":  true,
+	"blockchain.scripthash.get_history":  true,
+	"blockchain.scripthash.get_mempool":  true,
+	"blockchain.scripthash.listunspent":  true,
+	"blockchain.transaction.get":         true,
+	"blockchain.transaction.get_merkle":  true,
+	"blockchain.transaction.id_from_pos": true,
+	"mempool.get_fee_histogram":          true,
+	"server.banner":                      true,
+	"server.donation_address":            true,
+	"server.features":                    true,
+	"server.ping":                        true,
+}
+
+// IsIdempotent returns whether method only reads and can be retried.
+// Broadcasts are never idempotent: resending one blindly can't tell a lost
+// request from a rejected transaction.
+func IsIdempotent(method string) bool {
+	return idempotentMethods[method]
+}
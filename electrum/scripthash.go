@@ -0,0 +1,161 @@
+package electrum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// ScriptHash returns the Electrum script hash of an output script: the
+// reversed sha256 of the script, hex encoded.
+func ScriptHash(pkScript []byte) string {
+	hash := sha256.Sum256(pkScript)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return hex.EncodeToString(hash[:])
+}
+
+// AddressScriptHash returns the Electrum script hash of an address on the
+// network.
+func (net *Network) AddressScriptHash(address string) (string, error) {
+	addr, err := net.DecodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return "", err
+	}
+	return ScriptHash(pkScript), nil
+}
+
+// BlockchainScripthashSubscribe subscribes to transactions on a script hash
+// and returns the hash of the transaction history.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-scripthash-subscribe
+func (n *Node) BlockchainScripthashSubscribe(scripthash string) (<-chan string, error) {
+	return n.scripthashSubscribe(scripthash, nil)
+}
+
+// scripthashSubscribe subscribes to a script hash until stop is closed or the
+// node is closed.
+func (n *Node) scripthashSubscribe(scripthash string, stop <-chan struct{}) (<-chan string, error) {
+	msgs := n.listenPush("blockchain.scripthash.subscribe")
+	resp := &basicResp{}
+	if err := n.request("blockchain.scripthash.subscribe", []interface{}{scripthash}, resp); err != nil {
+		n.unlistenPush("blockchain.scripthash.subscribe", msgs)
+		return nil, err
+	}
+	n.scripthashSubsLock.Lock()
+	n.scripthashSubs[scripthash]++
+	n.scripthashSubsLock.Unlock()
+
+	statusChan := make(chan string, 1)
+	statusChan <- resp.Result
+	go func() {
+		defer close(statusChan)
+		defer n.scripthashUnsubscribe(scripthash, msgs)
+		for {
+			var msg []byte
+			var ok bool
+			select {
+			case <-stop:
+				return
+			case msg, ok = <-msgs:
+			}
+			if !ok {
+				return
+			}
+			resp := &struct {
+				Params []string `json:"params"`
+			}{}
+			if err := json.Unmarshal(msg, resp); err != nil {
+				log.Printf("ERR %s", err)
+				return
+			}
+			if len(resp.Params) != 2 {
+				log.Printf("scripthash subscription params len != 2 %+v", resp.Params)
+				continue
+			}
+			if resp.Params[0] != scripthash {
+				continue
+			}
+			select {
+			case statusChan <- resp.Params[1]:
+			case <-stop:
+				return
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	return statusChan, nil
+}
+
+// scripthashUnsubscribe stops listening for notifications on msgs and
+// unsubscribes from the server once nothing else follows the script hash.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-scripthash-unsubscribe
+func (n *Node) scripthashUnsubscribe(scripthash string, msgs <-chan []byte) {
+	n.unlistenPush("blockchain.scripthash.subscribe", msgs)
+	n.scripthashSubsLock.Lock()
+	n.scripthashSubs[scripthash]--
+	last := n.scripthashSubs[scripthash] <= 0
+	if last {
+		delete(n.scripthashSubs, scripthash)
+	}
+	n.scripthashSubsLock.Unlock()
+	if !last || n.State() == Closed {
+		return
+	}
+	var resp struct {
+		Result bool `json:"result"`
+	}
+	err := n.request("blockchain.scripthash.unsubscribe", []interface{}{scripthash}, &resp)
+	if err != nil && !isMethodNotFound(err) {
+		log.Printf("ERR %s", err)
+	}
+}
+
+// BlockchainScripthashGetHistory returns the history of a script hash.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-scripthash-get-history
+func (n *Node) BlockchainScripthashGetHistory(scripthash string) ([]*HistoryItem, error) {
+	resp := &struct {
+		Result []*HistoryItem `json:"result"`
+	}{}
+	err := n.request("blockchain.scripthash.get_history", []interface{}{scripthash}, resp)
+	return resp.Result, err
+}
+
+// BlockchainScripthashGetMempool returns the unconfirmed transactions of a
+// script hash.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-scripthash-get-mempool
+func (n *Node) BlockchainScripthashGetMempool(scripthash string) ([]*HistoryItem, error) {
+	resp := &struct {
+		Result []*HistoryItem `json:"result"`
+	}{}
+	err := n.request("blockchain.scripthash.get_mempool", []interface{}{scripthash}, resp)
+	return resp.Result, err
+}
+
+// BlockchainScripthashGetBalance returns the balance of a script hash.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-scripthash-get-balance
+func (n *Node) BlockchainScripthashGetBalance(scripthash string) (*Balance, error) {
+	resp := &struct {
+		Result *Balance `json:"result"`
+	}{}
+	err := n.request("blockchain.scripthash.get_balance", []interface{}{scripthash}, resp)
+	return resp.Result, err
+}
+
+// BlockchainScripthashListUnspent lists the unspent outputs of a script hash.
+// http://docs.electrum.org/en/latest/protocol-methods.html#blockchain-scripthash-listunspent
+func (n *Node) BlockchainScripthashListUnspent(scripthash string) ([]*UTXO, error) {
+	resp := &struct {
+		Result []*UTXO `json:"result"`
+	}{}
+	err := n.request("blockchain.scripthash.listunspent", []interface{}{scripthash}, resp)
+	return resp.Result, err
+}
@@ -1,13 +1,36 @@
 package electrum
 
+import "fmt"
+
 // ServerVersion returns the server's version.
 // http://docs.electrum.org/en/latest/protocol.html#server-version
 func (n *Node) ServerVersion() (string, error) {
 	resp := &basicResp{}
-	err := n.request("server.version", []string{ClientVersion, ProtocolVersion}, resp)
+	err := n.request("server.version", []interface{}{ClientVersion, ProtocolVersion}, resp)
 	return resp.Result, err
 }
 
+// ServerVersionRange negotiates a protocol version between min and max and
+// returns the server's software version and the negotiated protocol. Servers
+// that support none of them reply with an error and disconnect.
+// http://docs.electrum.org/en/latest/protocol-methods.html#server-version
+func (n *Node) ServerVersionRange(min, max string) (string, string, error) {
+	resp := &struct {
+		Result []string `json:"result"`
+	}{}
+	if err := n.request("server.version", []interface{}{ClientVersion, []string{min, max}}, resp); err != nil {
+		return "", "", err
+	}
+	if len(resp.Result) != 2 {
+		return "", "", fmt.Errorf("server.version returned %q, expected software and protocol version", resp.Result)
+	}
+	software, protocol := resp.Result[0], resp.Result[1]
+	if compareVersions(protocol, min) < 0 || compareVersions(protocol, max) > 0 {
+		return "", "", fmt.Errorf("server negotiated protocol %s outside of %s-%s", protocol, min, max)
+	}
+	return software, protocol, nil
+}
+
 // ServerBanner returns the server's banner.
 // http://docs.electrum.org/en/latest/protocol.html#server-banner
 func (n *Node) ServerBanner() (string, error) {
@@ -33,3 +56,52 @@ func (n *Node) ServerPeersSubscribe() ([][]interface{}, error) {
 	err := n.request("server.peers.subscribe", nil, resp)
 	return resp.Peers, err
 }
+
+// ServerPing pings the server.
+// http://docs.electrum.org/en/latest/protocol-methods.html#server-ping
+func (n *Node) ServerPing() error {
+	resp := &struct {
+		Result interface{} `json:"result"`
+	}{}
+	return n.request("server.ping", nil, resp)
+}
+
+// ServerFeatures describes the features supported by a server.
+type ServerFeatures struct {
+	GenesisHash   string                       `json:"genesis_hash"`
+	Hosts         map[string]map[string]uint16 `json:"hosts"`
+	ProtocolMax   string                       `json:"protocol_max"`
+	ProtocolMin   string                       `json:"protocol_min"`
+	Pruning       int                          `json:"pruning"`
+	ServerVersion string                       `json:"server_version"`
+	HashFunction  string                       `json:"hash_function"`
+}
+
+// ServerFeatures returns the features of the server.
+// http://docs.electrum.org/en/latest/protocol-methods.html#server-features
+func (n *Node) ServerFeatures() (*ServerFeatures, error) {
+	resp := &struct {
+		Result *ServerFeatures `json:"result"`
+	}{}
+	if err := n.request("server.features", nil, resp); err != nil {
+		return nil, err
+	}
+	if resp.Result == nil {
+		return nil, ErrEmptyResult
+	}
+	return resp.Result, nil
+}
+
+// CheckNetwork verifies that the server's genesis block matches the node's
+// network.
+func (n *Node) CheckNetwork() error {
+	features, err := n.ServerFeatures()
+	if err != nil {
+		return err
+	}
+	if features.GenesisHash != n.Network.Params.GenesisHash.String() {
+		return fmt.Errorf("server %s has genesis %s, expected %s (%s)",
+			n.Address, features.GenesisHash, n.Network.Params.GenesisHash, n.Network.Name())
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package electrum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// StatusHash computes the Electrum status of a history as returned by the
+// server: the sha256 of the concatenated "tx_hash:height:" entries. An empty
+// history has an empty status.
+func StatusHash(history []*HistoryItem) string {
+	if len(history) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, item := range history {
+		h.Write([]byte(item.TxID.String() + ":" + strconv.Itoa(protocolHeight(item.State, item.Height)) + ":"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// StatusMismatchError is reported when the history returned by a server
+// doesn't hash to the status it announced.
+type StatusMismatchError struct {
+	Server   string
+	Status   string
+	Computed string
+}
+
+func (e *StatusMismatchError) Error() string {
+	return fmt.Sprintf("server %s announced status %q but its history hashes to %q", e.Server, e.Status, e.Computed)
+}
+
+// HistoryUpdate is a verified history sent by a history subscription. Err is
+// a *StatusMismatchError if the history doesn't match the announced status,
+// which can also happen if the history changed again before it was fetched.
+type HistoryUpdate struct {
+	Status  string
+	History []*HistoryItem
+	Err     error
+}
+
+// BlockchainScripthashSubscribeHistory subscribes to a script hash and sends
+// its history whenever the status changes, verifying it against the status.
+func (n *Node) BlockchainScripthashSubscribeHistory(scripthash string) (<-chan *HistoryUpdate, error) {
+	return n.scripthashSubscribeHistory(scripthash, nil)
+}
+
+// scripthashSubscribeHistory follows the history of a script hash until stop
+// is closed or the node is closed.
+func (n *Node) scripthashSubscribeHistory(scripthash string, stop <-chan struct{}) (<-chan *HistoryUpdate, error) {
+	statuses, err := n.scripthashSubscribe(scripthash, stop)
+	if err != nil {
+		return nil, err
+	}
+	return n.subscribeHistory(statuses, func() ([]*HistoryItem, error) {
+		return n.BlockchainScripthashGetHistory(scripthash)
+	}, stop), nil
+}
+
+// BlockchainAddressSubscribeHistory subscribes to an address and sends its
+// history whenever the status changes, verifying it against the status.
+func (n *Node) BlockchainAddressSubscribeHistory(address string) (<-chan *HistoryUpdate, error) {
+	statuses, err := n.BlockchainAddressSubscribe(address)
+	if err != nil {
+		return nil, err
+	}
+	return n.subscribeHistory(statuses, func() ([]*HistoryItem, error) {
+		return n.BlockchainAddressGetHistory(address)
+	}, nil), nil
+}
+
+// subscribeHistory fetches the history for every new status until stop is
+// closed or the node is closed. Statuses equal to the last verified one are
+// skipped.
+func (n *Node) subscribeHistory(statuses <-chan string, getHistory func() ([]*HistoryItem, error), stop <-chan struct{}) <-chan *HistoryUpdate {
+	updates := make(chan *HistoryUpdate, 1)
+	go func() {
+		defer close(updates)
+		last := ""
+		first := true
+		for status := range statuses {
+			if !first && status == last {
+				continue
+			}
+			history, err := getHistory()
+			if err != nil {
+				log.Printf("ERR %s", err)
+				continue
+			}
+			update := &HistoryUpdate{Status: status, History: history}
+			if computed := StatusHash(history); computed != status {
+				update.Err = &StatusMismatchError{
+					Server:   n.Address,
+					Status:   status,
+					Computed: computed,
+				}
+			} else {
+				last = status
+				first = false
+			}
+			select {
+			case updates <- update:
+			case <-stop:
+				return
+			case <-n.done:
+				return
+			}
+		}
+	}()
+	return updates
+}
@@ -0,0 +1,87 @@
+package electrum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+func TestStatusHash(t *testing.T) {
+	history := []*HistoryItem{
+		{TxID: chainhash.Hash{1}, State: Confirmed, Height: 200},
+		{TxID: chainhash.Hash{2}, State: UnconfirmedParent},
+	}
+	sum := sha256.Sum256([]byte(history[0].TxID.String() + ":200:" + history[1].TxID.String() + ":-1:"))
+	if got, want := StatusHash(history), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("StatusHash = %s; want %s", got, want)
+	}
+	if got := StatusHash(nil); got != "" {
+		t.Errorf("StatusHash(nil) = %q", got)
+	}
+}
+
+func TestSubscribeHistory(t *testing.T) {
+	history := []*HistoryItem{{TxID: chainhash.Hash{1}, State: Confirmed, Height: 200}}
+	n, ft := newFakeNode()
+	fetches := make(chan struct{}, 10)
+	ft.handle("blockchain.scripthash.subscribe", func([]interface{}) (interface{}, string) {
+		return StatusHash(history), ""
+	})
+	ft.handle("blockchain.scripthash.get_history", func([]interface{}) (interface{}, string) {
+		fetches <- struct{}{}
+		return history, ""
+	})
+	updates, err := n.BlockchainScripthashSubscribeHistory("sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := <-updates; u.Err != nil || len(u.History) != 1 {
+		t.Fatalf("unexpected update %+v", u)
+	}
+
+	// Repeated status is skipped, a lying status is reported.
+	ft.push("blockchain.scripthash.subscribe", "sh", StatusHash(history))
+	ft.push("blockchain.scripthash.subscribe", "sh", "bogus")
+	select {
+	case u := <-updates:
+		if _, ok := u.Err.(*StatusMismatchError); !ok {
+			t.Fatalf("expected status mismatch, got %+v", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for update")
+	}
+	if len(fetches) != 2 {
+		t.Errorf("expected 2 history fetches, got %d", len(fetches))
+	}
+}
+
+func TestAddressSubscribeHistoryEmpty(t *testing.T) {
+	n, ft := newFakeNode()
+	ft.handle("blockchain.address.subscribe", func([]interface{}) (interface{}, string) {
+		return nil, ""
+	})
+	ft.handle("blockchain.address.get_history", func([]interface{}) (interface{}, string) {
+		return []*HistoryItem{}, ""
+	})
+	updates, err := n.BlockchainAddressSubscribeHistory("addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-updates:
+		if u.Err != nil || u.Status != "" || len(u.History) != 0 {
+			t.Fatalf("unexpected update %+v", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no initial update for an empty address")
+	}
+
+	// Forwarders blocked on an unread channel exit when the node closes.
+	ft.push("blockchain.address.subscribe", "addr", "status")
+	n.Close()
+	for range updates {
+	}
+}
@@ -5,40 +5,67 @@ import (
 	"crypto/tls"
 	"log"
 	"net"
+	"sync"
 )
 
 type TCPTransport struct {
 	conn      net.Conn
 	responses chan []byte
 	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Dialer opens network connections. A *socks.Proxy from
+// github.com/btcsuite/go-socks with TorIsolation set connects through Tor
+// using a separate circuit per connection.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
 }
 
 func NewTCPTransport(addr string) (*TCPTransport, error) {
-	conn, err := net.Dial("tcp", addr)
+	return NewDialerTransport(&net.Dialer{}, addr, nil)
+}
+
+func NewSSLTransport(addr string, config *tls.Config) (*TCPTransport, error) {
+	conn, err := tls.Dial("tcp", addr, config)
 	if err != nil {
 		return nil, err
 	}
-	t := &TCPTransport{
-		conn:      conn,
-		responses: make(chan []byte),
-		errors:    make(chan error),
-	}
-	go t.listen()
-	return t, nil
+	return newTCPTransport(conn), nil
 }
 
-func NewSSLTransport(addr string, config *tls.Config) (*TCPTransport, error) {
-	conn, err := tls.Dial("tcp", addr, config)
+// NewDialerTransport connects to addr using dialer, wrapping the connection
+// in TLS if config isn't nil.
+func NewDialerTransport(dialer Dialer, addr string, config *tls.Config) (*TCPTransport, error) {
+	conn, err := dialer.Dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	if config != nil {
+		if len(config.ServerName) == 0 && !config.InsecureSkipVerify {
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return newTCPTransport(conn), nil
+}
+
+func newTCPTransport(conn net.Conn) *TCPTransport {
 	t := &TCPTransport{
 		conn:      conn,
 		responses: make(chan []byte),
 		errors:    make(chan error),
+		done:      make(chan struct{}),
 	}
 	go t.listen()
-	return t, nil
+	return t
 }
 
 func (t *TCPTransport) SendMessage(body []byte) error {
@@ -47,6 +74,14 @@ func (t *TCPTransport) SendMessage(body []byte) error {
 	return err
 }
 
+// Close closes the connection, which stops listen.
+func (t *TCPTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+	return t.conn.Close()
+}
+
 const delim = byte('\n')
 
 func (t *TCPTransport) listen() {
@@ -55,12 +90,19 @@ func (t *TCPTransport) listen() {
 	for {
 		line, err := reader.ReadBytes(delim)
 		if err != nil {
-			t.errors <- err
-			log.Printf("error %s", err)
-			break
+			select {
+			case t.errors <- err:
+				log.Printf("error %s", err)
+			case <-t.done:
+			}
+			return
 		}
 		log.Printf("%s -> %s", t.conn.RemoteAddr(), line)
-		t.responses <- line
+		select {
+		case t.responses <- line:
+		case <-t.done:
+			return
+		}
 	}
 }
 
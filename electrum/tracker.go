@@ -0,0 +1,353 @@
+package electrum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"log"
+	"sync"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TxEventKind is the kind of state transition reported by a Tracker.
+type TxEventKind int
+
+const (
+	// TxSeen is sent when the transaction enters the mempool.
+	TxSeen TxEventKind = iota
+	// TxConfirmed is sent when the transaction is included in a block.
+	TxConfirmed
+	// TxFinal is sent when the transaction reaches the requested number of
+	// confirmations.
+	TxFinal
+	// TxReorged is sent when the block including the transaction is
+	// disconnected.
+	TxReorged
+	// TxDropped is sent when the transaction disappears without a known
+	// conflict.
+	TxDropped
+	// TxConflicted is sent when another transaction spends one of the
+	// transaction's inputs.
+	TxConflicted
+)
+
+func (k TxEventKind) String() string {
+	switch k {
+	case TxSeen:
+		return "seen"
+	case TxConfirmed:
+		return "confirmed"
+	case TxFinal:
+		return "final"
+	case TxReorged:
+		return "reorged"
+	case TxDropped:
+		return "dropped"
+	case TxConflicted:
+		return "conflicted"
+	}
+	return "unknown"
+}
+
+// TxEvent is a state transition of a tracked transaction.
+type TxEvent struct {
+	Kind TxEventKind
+	// Height and Confirmations are set for confirmed transactions.
+	Height        int
+	Confirmations int
+	// ConflictTxID is the conflicting transaction for TxConflicted.
+	ConflictTxID chainhash.Hash
+}
+
+// Tracker follows a transaction until it has a number of confirmations.
+type Tracker struct {
+	node          *Node
+	chain         *ChainTracker
+	txid          chainhash.Hash
+	scripthash    string
+	confirmations int
+
+	events      chan *TxEvent
+	chainEvents <-chan ChainEvent
+	spends      chan *SpendEvent
+	stop        chan struct{}
+	stopOnce    sync.Once
+
+	// The following are only used by the tracker's goroutine.
+	watched  bool
+	inputs   []wire.OutPoint
+	seen     bool
+	height   int
+	pending  int
+	final    bool
+	conflict chainhash.Hash
+}
+
+// NewTracker tracks the transaction txid paying to pkScript until it has the
+// given number of confirmations. The chain tracker provides the tip; its
+// header store is used to verify merkle proofs. The inputs of the transaction
+// are watched with BlockchainOutpointSubscribe to detect conflicts.
+func NewTracker(n *Node, chain *ChainTracker, txid chainhash.Hash, pkScript []byte, confirmations int) (*Tracker, error) {
+	t := &Tracker{
+		node:          n,
+		chain:         chain,
+		txid:          txid,
+		scripthash:    ScriptHash(pkScript),
+		confirmations: confirmations,
+		events:        make(chan *TxEvent, 4),
+		spends:        make(chan *SpendEvent, 1),
+		stop:          make(chan struct{}),
+	}
+	updates, err := n.scripthashSubscribeHistory(t.scripthash, t.stop)
+	if err != nil {
+		return nil, err
+	}
+	t.chainEvents = chain.Subscribe()
+	go func() {
+		defer close(t.events)
+		defer t.unwatchInputs()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case update, ok := <-updates:
+				if !ok {
+					return
+				}
+				t.processHistory(update.History)
+			case ev, ok := <-t.chainEvents:
+				if !ok {
+					return
+				}
+				t.processChain(ev)
+			case ev := <-t.spends:
+				t.processSpend(ev)
+			}
+			t.checkFinal(chain.Headers().Tip())
+		}
+	}()
+	return t, nil
+}
+
+// Events returns the channel of state transitions. It's closed when the
+// tracker stops.
+func (t *Tracker) Events() <-chan *TxEvent {
+	return t.events
+}
+
+// Stop stops tracking the transaction. It's safe to call more than once.
+func (t *Tracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		t.chain.Unsubscribe(t.chainEvents)
+	})
+}
+
+func (t *Tracker) emit(ev *TxEvent) {
+	select {
+	case t.events <- ev:
+	case <-t.stop:
+	}
+}
+
+// processHistory updates the state from the history of the script.
+func (t *Tracker) processHistory(history []*HistoryItem) {
+	var item *HistoryItem
+	for _, h := range history {
+		if h.TxID == t.txid {
+			item = h
+		}
+	}
+	if item == nil {
+		if !t.seen {
+			return
+		}
+		if t.height > 0 {
+			t.emit(&TxEvent{Kind: TxReorged})
+		}
+		t.seen, t.height, t.pending, t.final = false, 0, 0, false
+		if t.conflict == (chainhash.Hash{}) {
+			t.emit(&TxEvent{Kind: TxDropped})
+		}
+		return
+	}
+
+	if !t.seen {
+		t.seen = true
+		t.watchInputs()
+		if item.State != Confirmed {
+			t.emit(&TxEvent{Kind: TxSeen})
+		}
+	}
+	if item.State != Confirmed {
+		t.pending = 0
+		if t.height > 0 {
+			t.height, t.final = 0, false
+			t.emit(&TxEvent{Kind: TxReorged})
+		}
+		return
+	}
+	if item.Height == t.height {
+		t.pending = 0
+		return
+	}
+	t.confirm(item.Height)
+}
+
+// confirm reports the transaction as confirmed at height once its merkle
+// proof is verified. Until then height stays pending and is retried on every
+// chain event, e.g. because the header wasn't stored yet.
+func (t *Tracker) confirm(height int) {
+	if err := t.verify(height); err != nil {
+		log.Printf("ERR tracker %s", err)
+		t.pending = height
+		return
+	}
+	t.pending = 0
+	if t.height > 0 {
+		t.emit(&TxEvent{Kind: TxReorged})
+	}
+	t.height, t.final = height, false
+	t.emit(&TxEvent{Kind: TxConfirmed, Height: t.height})
+}
+
+// processChain updates the state after a chain event. A reorg may have moved
+// the transaction to another block, even at the same height, without the
+// script's status changing, so the history is fetched again.
+func (t *Tracker) processChain(ev ChainEvent) {
+	reorg, ok := ev.(Reorg)
+	if !ok {
+		if t.pending > 0 {
+			t.confirm(t.pending)
+		}
+		return
+	}
+	if t.height > 0 && uint64(t.height) > reorg.ForkHeight {
+		t.height, t.final = 0, false
+		t.emit(&TxEvent{Kind: TxReorged})
+	}
+	if !t.seen {
+		return
+	}
+	history, err := t.node.BlockchainScripthashGetHistory(t.scripthash)
+	if err != nil {
+		log.Printf("ERR tracker %s", err)
+		return
+	}
+	t.processHistory(history)
+}
+
+// processSpend reports a transaction other than the tracked one spending one
+// of its inputs.
+func (t *Tracker) processSpend(ev *SpendEvent) {
+	if !ev.Spent || ev.SpenderTxID == t.txid || ev.SpenderTxID == t.conflict {
+		return
+	}
+	t.conflict = ev.SpenderTxID
+	t.emit(&TxEvent{Kind: TxConflicted, ConflictTxID: ev.SpenderTxID})
+}
+
+// checkFinal sends TxFinal once the transaction is buried deep enough.
+func (t *Tracker) checkFinal(tip *BlockchainHeader) {
+	if t.final || t.height <= 0 || tip == nil {
+		return
+	}
+	confs := int(tip.BlockHeight) - t.height + 1
+	if confs >= t.confirmations {
+		t.final = true
+		t.emit(&TxEvent{Kind: TxFinal, Height: t.height, Confirmations: confs})
+	}
+}
+
+// verify checks the merkle proof of the transaction if the node has a header
+// store.
+func (t *Tracker) verify(height int) error {
+	if t.node.Headers == nil {
+		return nil
+	}
+	proof, err := t.node.BlockchainTransactionGetMerkle(t.txid.String(), uint64(height))
+	if err != nil {
+		return err
+	}
+	return t.node.VerifyMerkle(t.txid.String(), proof.Merkle, proof.Pos, uint64(height))
+}
+
+// watchInputs subscribes to the outputs spent by the transaction, forwarding
+// their spend events to the tracker.
+func (t *Tracker) watchInputs() {
+	if t.watched {
+		return
+	}
+	t.watched = true
+	tx, err := t.node.transaction(t.txid.String(), 0)
+	if err != nil {
+		log.Printf("ERR tracker %s", err)
+		return
+	}
+	if blockchain.IsCoinBaseTx(tx) {
+		return
+	}
+	for _, in := range tx.TxIn {
+		op := in.PreviousOutPoint
+		parent, err := t.node.transaction(op.Hash.String(), 0)
+		if err != nil {
+			log.Printf("ERR tracker %s", err)
+			continue
+		}
+		if int(op.Index) >= len(parent.TxOut) {
+			log.Printf("ERR tracker prevout %s doesn't exist", op)
+			continue
+		}
+		spends, err := t.node.BlockchainOutpointSubscribe(op, parent.TxOut[op.Index].PkScript)
+		if err != nil {
+			log.Printf("ERR tracker %s", err)
+			continue
+		}
+		t.inputs = append(t.inputs, op)
+		go func() {
+			for ev := range spends {
+				select {
+				case t.spends <- ev:
+				case <-t.stop:
+					return
+				case <-t.node.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// unwatchInputs unsubscribes from the outputs spent by the transaction.
+func (t *Tracker) unwatchInputs() {
+	for _, op := range t.inputs {
+		if err := t.node.BlockchainOutpointUnsubscribe(op); err != nil && err != ErrNodeClosed {
+			log.Printf("ERR tracker %s", err)
+		}
+	}
+}
+
+// transaction fetches and decodes a transaction confirmed at height, or 0 if
+// it may be unconfirmed.
+func (n *Node) transaction(txid string, height int) (*wire.MsgTx, error) {
+	txHex, err := n.BlockchainTransactionGetConfirmed(txid, height)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTx(txHex)
+}
+
+// decodeTx decodes a hex encoded transaction.
+func decodeTx(txHex string) (*wire.MsgTx, error) {
+	b, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,236 @@
+package electrum
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func nextTxEvent(t *testing.T, tracker *Tracker) *TxEvent {
+	select {
+	case ev := <-tracker.Events():
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for tx event")
+	}
+	return nil
+}
+
+func noTxEvent(t *testing.T, tracker *Tracker) {
+	select {
+	case ev := <-tracker.Events():
+		t.Fatalf("unexpected event %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// trackerTest is a tracker following tx, paying to pkScript, on a fake node
+// whose chain tip is headers[2]. headers[3] includes tx.
+type trackerTest struct {
+	n        *Node
+	ft       *fakeTransport
+	chain    *ChainTracker
+	tracker  *Tracker
+	tx       *wire.MsgTx
+	txid     chainhash.Hash
+	pkScript []byte
+	headers  []*BlockchainHeader
+
+	historyLock sync.Mutex
+	history     []*HistoryItem
+}
+
+func newTrackerTest(t *testing.T) *trackerTest {
+	tt := &trackerTest{pkScript: []byte{0x51}}
+	parent := wire.NewMsgTx(1)
+	parent.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	parent.AddTxOut(wire.NewTxOut(2000, []byte{0x52}))
+	parentHash := parent.TxHash()
+	tt.tx = wire.NewMsgTx(1)
+	tt.tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parentHash, 0), nil, nil))
+	tt.tx.AddTxOut(wire.NewTxOut(1000, tt.pkScript))
+	tt.txid = tt.tx.TxHash()
+
+	tt.headers = makeChain(t, nil, 3, 0)
+	tt.headers = append(tt.headers, tt.block(t, tt.headers[2], 0))
+	tt.headers = append(tt.headers, makeChain(t, tt.headers[3], 1, 0)...)
+
+	tt.n, tt.ft = newFakeNode()
+	tt.n.Network = RegTest
+	tt.ft.handle("blockchain.headers.subscribe", func([]interface{}) (interface{}, string) {
+		return tt.headers[2], ""
+	})
+	tt.ft.handle("blockchain.scripthash.subscribe", func([]interface{}) (interface{}, string) {
+		tt.historyLock.Lock()
+		defer tt.historyLock.Unlock()
+		return StatusHash(tt.history), ""
+	})
+	tt.ft.handle("blockchain.scripthash.get_history", func([]interface{}) (interface{}, string) {
+		tt.historyLock.Lock()
+		defer tt.historyLock.Unlock()
+		return tt.history, ""
+	})
+	tt.ft.handle("blockchain.transaction.get", func(params []interface{}) (interface{}, string) {
+		if params[0] == parentHash.String() {
+			return txHex(t, parent), ""
+		}
+		return txHex(t, tt.tx), ""
+	})
+	tt.ft.handle("blockchain.transaction.get_merkle", func(params []interface{}) (interface{}, string) {
+		return MerkleProof{BlockHeight: 3, Merkle: []string{}, Pos: 0}, ""
+	})
+	tt.ft.handle("blockchain.outpoint.subscribe", func([]interface{}) (interface{}, string) {
+		return map[string]interface{}{"height": 1}, ""
+	})
+	tt.ft.handle("blockchain.outpoint.unsubscribe", func([]interface{}) (interface{}, string) {
+		return true, ""
+	})
+
+	var err error
+	if tt.chain, err = tt.n.TrackChainTip(); err != nil {
+		t.Fatal(err)
+	}
+	<-tt.chain.Events()
+	if tt.tracker, err = NewTracker(tt.n, tt.chain, tt.txid, tt.pkScript, 2); err != nil {
+		t.Fatal(err)
+	}
+	return tt
+}
+
+// block returns a header on top of parent whose merkle root is the tracked
+// transaction, using branch to tell blocks apart.
+func (tt *trackerTest) block(t *testing.T, parent *BlockchainHeader, branch uint64) *BlockchainHeader {
+	block := makeChain(t, parent, 1, branch)[0]
+	block.MerkleRoot = tt.txid.String()
+	for block.Nonce = 0; RegTest.CheckHeader(block) != nil; block.Nonce++ {
+	}
+	return block
+}
+
+// setHistory changes the history and announces the new status.
+func (tt *trackerTest) setHistory(h []*HistoryItem) {
+	tt.historyLock.Lock()
+	tt.history = h
+	tt.historyLock.Unlock()
+	tt.ft.push("blockchain.scripthash.subscribe", ScriptHash(tt.pkScript), StatusHash(h))
+}
+
+// pushHeader announces a new tip and waits for the chain tracker to store it.
+func (tt *trackerTest) pushHeader(h *BlockchainHeader) {
+	tt.ft.push("blockchain.headers.subscribe", h)
+	<-tt.chain.Events()
+}
+
+func TestTracker(t *testing.T) {
+	tt := newTrackerTest(t)
+	tracker := tt.tracker
+	defer tracker.Stop()
+
+	tt.setHistory([]*HistoryItem{{TxID: tt.txid, State: Mempool}})
+	if ev := nextTxEvent(t, tracker); ev.Kind != TxSeen {
+		t.Fatalf("expected seen, got %+v", ev)
+	}
+
+	tt.pushHeader(tt.headers[3])
+	tt.setHistory([]*HistoryItem{{TxID: tt.txid, State: Confirmed, Height: 3}})
+	if ev := nextTxEvent(t, tracker); ev.Kind != TxConfirmed || ev.Height != 3 {
+		t.Fatalf("expected confirmed at 3, got %+v", ev)
+	}
+
+	tt.pushHeader(tt.headers[4])
+	if ev := nextTxEvent(t, tracker); ev.Kind != TxFinal || ev.Confirmations != 2 {
+		t.Fatalf("expected final with 2 confirmations, got %+v", ev)
+	}
+
+	tt.setHistory(nil)
+	if ev := nextTxEvent(t, tracker); ev.Kind != TxReorged {
+		t.Fatalf("expected reorged, got %+v", ev)
+	}
+	if ev := nextTxEvent(t, tracker); ev.Kind != TxDropped {
+		t.Fatalf("expected dropped, got %+v", ev)
+	}
+
+	tracker.Stop()
+	tracker.Stop()
+	tt.chain.subscribersLock.Lock()
+	defer tt.chain.subscribersLock.Unlock()
+	if len(tt.chain.subscribers) != 0 {
+		t.Errorf("expected no chain subscribers after Stop, got %d", len(tt.chain.subscribers))
+	}
+}
+
+func TestTrackerHistoryBeforeHeader(t *testing.T) {
+	tt := newTrackerTest(t)
+	defer tt.tracker.Stop()
+
+	// The proof can't be verified before the header at height 3 is known.
+	tt.setHistory([]*HistoryItem{{TxID: tt.txid, State: Confirmed, Height: 3}})
+	noTxEvent(t, tt.tracker)
+
+	tt.pushHeader(tt.headers[3])
+	if ev := nextTxEvent(t, tt.tracker); ev.Kind != TxConfirmed || ev.Height != 3 {
+		t.Fatalf("expected confirmed at 3, got %+v", ev)
+	}
+}
+
+func TestTrackerReorgSameHeight(t *testing.T) {
+	tt := newTrackerTest(t)
+	defer tt.tracker.Stop()
+
+	tt.pushHeader(tt.headers[3])
+	tt.setHistory([]*HistoryItem{{TxID: tt.txid, State: Confirmed, Height: 3}})
+	if ev := nextTxEvent(t, tt.tracker); ev.Kind != TxConfirmed || ev.Height != 3 {
+		t.Fatalf("expected confirmed at 3, got %+v", ev)
+	}
+
+	// The transaction is mined again at the same height, so its status
+	// doesn't change.
+	tt.pushHeader(tt.block(t, tt.headers[2], 1))
+	if ev := nextTxEvent(t, tt.tracker); ev.Kind != TxReorged {
+		t.Fatalf("expected reorged, got %+v", ev)
+	}
+	if ev := nextTxEvent(t, tt.tracker); ev.Kind != TxConfirmed || ev.Height != 3 {
+		t.Fatalf("expected confirmed at 3 again, got %+v", ev)
+	}
+}
+
+func TestTrackerConflict(t *testing.T) {
+	tt := newTrackerTest(t)
+	defer tt.tracker.Stop()
+
+	tt.setHistory([]*HistoryItem{{TxID: tt.txid, State: Mempool}})
+	if ev := nextTxEvent(t, tt.tracker); ev.Kind != TxSeen {
+		t.Fatalf("expected seen, got %+v", ev)
+	}
+
+	// A double spend pays a different script, so only the input's
+	// subscription sees it.
+	op := tt.tx.TxIn[0].PreviousOutPoint
+	conflict := chainhash.Hash{2}
+	status := map[string]interface{}{"height": 1, "spender_txhash": conflict.String(), "spender_height": 0}
+	// The input subscription is made before TxSeen, so the push is seen.
+	tt.ft.push("blockchain.outpoint.subscribe", []interface{}{op.Hash.String(), op.Index}, status)
+	if ev := nextTxEvent(t, tt.tracker); ev.Kind != TxConflicted || ev.ConflictTxID != conflict {
+		t.Fatalf("expected conflict with %s, got %+v", conflict, ev)
+	}
+
+	tt.setHistory(nil)
+	noTxEvent(t, tt.tracker)
+
+	tt.tracker.Stop()
+	for i := 0; ; i++ {
+		tt.n.outpointSubsLock.Lock()
+		subs := len(tt.n.outpointSubs)
+		tt.n.outpointSubsLock.Unlock()
+		if subs == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("inputs still subscribed after Stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
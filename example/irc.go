@@ -1,11 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/d4l3k/go-electrum/irc"
 )
 
 func main() {
-	log.Println(irc.FindElectrumServers())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	servers, malformed, err := irc.FindElectrumServers(ctx, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, server := range servers {
+		log.Printf("%+v", server)
+	}
+	for _, entry := range malformed {
+		log.Printf("malformed: %s", entry)
+	}
 }
@@ -11,6 +11,7 @@ func main() {
 	if err := node.ConnectTCP("btc.mustyoshi.com:50001"); err != nil {
 		log.Fatal(err)
 	}
+	defer node.Close()
 
 	version, err := node.ServerVersion()
 	if err != nil {
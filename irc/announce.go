@@ -0,0 +1,139 @@
+package irc
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/d4l3k/go-electrum/electrum"
+)
+
+// Reconnection delays after the announcement connection fails. The delay
+// doubles after every failed attempt.
+var (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+// Announcement keeps an Electrum server announced in the IRC channel.
+type Announcement struct {
+	opts    Options
+	updates chan *electrum.Peer
+	stop    chan struct{}
+	close   sync.Once
+	done    chan struct{}
+}
+
+// Announce joins the channel with an "E_" nick whose realname is the
+// descriptor of peer, the way Electrum servers announce themselves. The first
+// connection is made before returning; afterwards the announcement reconnects
+// until it's closed, which quits gracefully, or ctx is done, which drops the
+// connection. A nil opts uses the defaults.
+func Announce(ctx context.Context, opts *Options, peer *electrum.Peer) (*Announcement, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	c, err := announce(ctx, opts, peer)
+	if err != nil {
+		return nil, err
+	}
+	a := &Announcement{
+		opts:    *opts,
+		updates: make(chan *electrum.Peer),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.run(ctx, c, peer)
+	return a, nil
+}
+
+// announce connects with the descriptor as realname and joins the channel.
+func announce(ctx context.Context, opts *Options, peer *electrum.Peer) (*conn, error) {
+	c, err := dial(ctx, opts, serverNickPrefix, peer.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.send("JOIN %s", opts.channel()); err != nil {
+		c.Close()
+		return nil, ctxErr(ctx, err)
+	}
+	return c, nil
+}
+
+// Update changes the announced descriptor. IRC doesn't allow changing the
+// realname, so the announcement reconnects.
+func (a *Announcement) Update(peer *electrum.Peer) {
+	select {
+	case a.updates <- peer:
+	case <-a.done:
+	}
+}
+
+// Close leaves the channel and stops the announcement.
+func (a *Announcement) Close() error {
+	a.close.Do(func() {
+		close(a.stop)
+	})
+	<-a.done
+	return nil
+}
+
+// Done is closed once the announcement stopped.
+func (a *Announcement) Done() <-chan struct{} {
+	return a.done
+}
+
+// run holds the connection open, reconnecting when it drops or the
+// descriptor changes.
+func (a *Announcement) run(ctx context.Context, c *conn, peer *electrum.Peer) {
+	defer close(a.done)
+	for {
+		errs := make(chan error, 1)
+		go func(c *conn) {
+			for {
+				if _, err := c.read(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(c)
+
+		wait := time.Duration(0)
+		select {
+		case <-ctx.Done():
+			c.Close()
+			return
+		case <-a.stop:
+			c.Close()
+			return
+		case peer = <-a.updates:
+			c.Close()
+		case err := <-errs:
+			log.Printf("ERR irc announce %s", err)
+			c.Close()
+			wait = minReconnectDelay
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.stop:
+				return
+			case peer = <-a.updates:
+			case <-time.After(wait):
+			}
+			var err error
+			if c, err = announce(ctx, &a.opts, peer); err == nil {
+				break
+			}
+			log.Printf("ERR irc announce %s", err)
+			if wait *= 2; wait < minReconnectDelay {
+				wait = minReconnectDelay
+			} else if wait > maxReconnectDelay {
+				wait = maxReconnectDelay
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package irc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/go-electrum/electrum"
+)
+
+func TestAnnounce(t *testing.T) {
+	minReconnectDelay = 10 * time.Millisecond
+	s := newFakeServer(t)
+	peer := &electrum.Peer{Host: "ours.example", Version: "1.4", TCPPort: "50001", SSLPort: "50002"}
+
+	a, err := Announce(context.Background(), s.options(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nick := s.expect(t, "NICK "); !strings.HasPrefix(nick, "NICK E_") {
+		t.Errorf("announced with nick %q", nick)
+	}
+	if user := s.expect(t, "USER "); !strings.HasSuffix(user, ":ours.example v1.4 t50001 s50002") {
+		t.Errorf("announced with %q", user)
+	}
+	s.expect(t, "JOIN #electrum")
+
+	a.Update(&electrum.Peer{Host: "ours.example", Version: "1.4", SSLPort: "50002"})
+	if user := s.expect(t, "USER "); !strings.HasSuffix(user, ":ours.example v1.4 s50002") {
+		t.Errorf("updated to %q", user)
+	}
+	s.expect(t, "JOIN #electrum")
+
+	s.drop()
+	if user := s.expect(t, "USER "); !strings.HasSuffix(user, ":ours.example v1.4 s50002") {
+		t.Errorf("reconnected with %q", user)
+	}
+	s.expect(t, "JOIN #electrum")
+
+	a.Close()
+	s.expect(t, "QUIT")
+	select {
+	case <-a.Done():
+	default:
+		t.Errorf("announcement not done after Close")
+	}
+}
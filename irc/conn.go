@@ -0,0 +1,196 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// IRC numerics used by the package, see RFC 2812.
+const (
+	rplWelcome      = "001"
+	rplTryAgain     = "263"
+	rplWhoisUser    = "311"
+	rplEndOfWhois   = "318"
+	rplNamReply     = "353"
+	rplEndOfNames   = "366"
+	errNicknameUsed = "433"
+)
+
+// isError reports whether command is an error numeric, 400 to 599.
+func isError(command string) bool {
+	if len(command) != 3 || (command[0] != '4' && command[0] != '5') {
+		return false
+	}
+	return command[1] >= '0' && command[1] <= '9' && command[2] >= '0' && command[2] <= '9'
+}
+
+// message is a parsed IRC protocol line.
+type message struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// parseMessage parses a line like ":prefix COMMAND a b :trailing param".
+func parseMessage(line string) *message {
+	m := &message{}
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			m.Prefix = line[1:]
+			return m
+		}
+		m.Prefix, line = line[1:i], line[i+1:]
+	}
+	var trailing string
+	hasTrailing := false
+	if i := strings.Index(line, " :"); i >= 0 {
+		line, trailing, hasTrailing = line[:i], line[i+2:], true
+	} else if strings.HasPrefix(line, ":") {
+		line, trailing, hasTrailing = "", line[1:], true
+	}
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		m.Command = strings.ToUpper(fields[0])
+		m.Params = fields[1:]
+	}
+	if hasTrailing {
+		m.Params = append(m.Params, trailing)
+	}
+	return m
+}
+
+// param returns the i-th parameter or "".
+func (m *message) param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// conn is a registered connection to an IRC server.
+type conn struct {
+	c    net.Conn
+	r    *textproto.Reader
+	nick string
+
+	stop chan struct{}
+}
+
+// dial connects to the server and registers with a nick starting with
+// prefix, picking another one if it's taken, and the realname, which defaults
+// to the nick. The connection is closed when ctx is done.
+func dial(ctx context.Context, opts *Options, prefix, realname string) (*conn, error) {
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", opts.server())
+	if err != nil {
+		return nil, err
+	}
+	if opts.TLS != nil {
+		config := opts.TLS
+		if len(config.ServerName) == 0 && !config.InsecureSkipVerify {
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(opts.server())
+		}
+		nc = tls.Client(nc, config)
+	}
+	c := &conn{
+		c:    nc,
+		r:    textproto.NewReader(bufio.NewReader(nc)),
+		stop: make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+		case <-c.stop:
+		}
+	}()
+	if err := c.register(prefix, realname); err != nil {
+		c.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// register picks a nick and waits for the server to welcome it.
+func (c *conn) register(prefix, realname string) error {
+	c.nick = randomNick(prefix)
+	if len(realname) == 0 {
+		realname = c.nick
+	}
+	if err := c.send("NICK %s", c.nick); err != nil {
+		return err
+	}
+	if err := c.send("USER %s 0 * :%s", c.nick, realname); err != nil {
+		return err
+	}
+	for {
+		m, err := c.read()
+		if err != nil {
+			return err
+		}
+		switch m.Command {
+		case rplWelcome:
+			return nil
+		case errNicknameUsed:
+			c.nick = randomNick(prefix)
+			if err := c.send("NICK %s", c.nick); err != nil {
+				return err
+			}
+		case "ERROR":
+			return fmt.Errorf("irc: server closed connection: %s", m.param(0))
+		}
+	}
+}
+
+// randomNick appends a random number to prefix.
+func randomNick(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, rand.Int31())
+}
+
+// send writes a command line.
+func (c *conn) send(format string, args ...interface{}) error {
+	c.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := fmt.Fprintf(c.c, format+"\r\n", args...)
+	return err
+}
+
+// read returns the next message, answering PINGs along the way.
+func (c *conn) read() (*message, error) {
+	for {
+		line, err := c.r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		m := parseMessage(line)
+		if m.Command == "PING" {
+			if err := c.send("PONG :%s", m.param(0)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return m, nil
+	}
+}
+
+// Close quits and closes the connection.
+func (c *conn) Close() error {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	c.send("QUIT")
+	return c.c.Close()
+}
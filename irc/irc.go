@@ -1,72 +1,210 @@
 package irc
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
-	"math/rand"
+	"log"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/thoj/go-ircevent"
+	"github.com/d4l3k/go-electrum/electrum"
 )
 
-var (
-	UsernameTemplate = "go-electrum-%d"
-	IRCServer        = "chat.freenode.net:8000"
-	IRCChannel       = "#electrum"
+const (
+	// DefaultServer is used when Options.Server is empty. Freenode, where
+	// Electrum servers used to announce themselves, is gone.
+	DefaultServer = "irc.libera.chat:6667"
+	// DefaultChannel is used when Options.Channel is empty.
+	DefaultChannel = "#electrum"
+	// DefaultNickPrefix is used when Options.NickPrefix is empty.
+	DefaultNickPrefix = "go-electrum-"
+
+	// serverNickPrefix marks nicks of announced Electrum servers.
+	serverNickPrefix = "E_"
+
+	// DefaultTimeout bounds FindElectrumServers when ctx has no deadline.
+	DefaultTimeout = time.Minute
+
+	writeTimeout = 30 * time.Second
 )
 
-// FindElectrumServersIRC finds nodes to connect to by connecting to the Freenode #electrum channel.
-func FindElectrumServers() ([]string, error) {
-	user := fmt.Sprintf(UsernameTemplate, rand.Int31())
-	ircobj := irc.IRC(user, user)
-	ircobj.UseTLS = false
-	ircobj.TLSConfig = &tls.Config{
-		InsecureSkipVerify: true,
+// Options configures the IRC connection. The zero value connects to
+// DefaultServer without TLS.
+type Options struct {
+	// Server is the host:port of the IRC server.
+	Server string
+	// Channel is the channel servers announce themselves in.
+	Channel string
+	// TLS enables TLS with the given config if not nil.
+	TLS *tls.Config
+	// NickPrefix is the start of our nick, a random number is appended.
+	NickPrefix string
+	// Network provides default server ports, electrum.MainNet if nil.
+	Network *electrum.Network
+}
+
+func (o *Options) server() string {
+	if len(o.Server) == 0 {
+		return DefaultServer
+	}
+	return o.Server
+}
+
+func (o *Options) channel() string {
+	if len(o.Channel) == 0 {
+		return DefaultChannel
+	}
+	return o.Channel
+}
+
+func (o *Options) nickPrefix() string {
+	if len(o.NickPrefix) == 0 {
+		return DefaultNickPrefix
+	}
+	return o.NickPrefix
+}
+
+func (o *Options) network() *electrum.Network {
+	if o.Network == nil {
+		return electrum.MainNet
+	}
+	return o.Network
+}
+
+// MalformedEntry is a WHOIS realname of an "E_" nick that isn't a valid
+// server descriptor.
+type MalformedEntry struct {
+	Nick     string
+	Realname string
+	Err      error
+}
+
+func (e *MalformedEntry) Error() string {
+	return fmt.Sprintf("%s announced malformed descriptor %q: %s", e.Nick, e.Realname, e.Err)
+}
+
+// FindElectrumServers lists the "E_" nicks in the channel and parses their
+// WHOIS realnames into peers. Realnames that aren't valid descriptors are
+// returned as malformed entries. It returns once every WHOIS has been
+// answered or ctx is done, DefaultTimeout applies if ctx has no deadline; a
+// nil opts uses the defaults.
+func FindElectrumServers(ctx context.Context, opts *Options) ([]*electrum.Peer, []*MalformedEntry, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+	c, err := dial(ctx, opts, opts.nickPrefix(), "")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.Close()
+
+	nicks, err := c.names(opts.channel())
+	if err != nil {
+		return nil, nil, ctxErr(ctx, err)
 	}
-	if err := ircobj.Connect(IRCServer); err != nil {
+
+	pending := make(map[string]bool)
+	for _, nick := range nicks {
+		if !strings.HasPrefix(nick, serverNickPrefix) || pending[strings.ToLower(nick)] {
+			continue
+		}
+		pending[strings.ToLower(nick)] = true
+		if err := c.send("WHOIS %s", nick); err != nil {
+			return nil, nil, ctxErr(ctx, err)
+		}
+	}
+
+	var servers []*electrum.Peer
+	var malformed []*MalformedEntry
+	// RPL_TRYAGAIN names the command instead of the nick, so each one only
+	// tells us that some WHOIS won't be answered.
+	dropped := 0
+	for len(pending) > dropped {
+		m, err := c.read()
+		if err != nil {
+			return nil, nil, ctxErr(ctx, err)
+		}
+		if m.Command == rplTryAgain && strings.EqualFold(m.param(1), "WHOIS") {
+			dropped++
+			continue
+		}
+		nick := m.param(1)
+		if !pending[strings.ToLower(nick)] {
+			continue
+		}
+		switch m.Command {
+		case rplWhoisUser:
+			realname := strings.TrimSpace(m.param(len(m.Params) - 1))
+			peer, err := electrum.ParsePeer(realname, opts.network())
+			if err != nil {
+				malformed = append(malformed, &MalformedEntry{Nick: nick, Realname: realname, Err: err})
+				continue
+			}
+			servers = append(servers, peer)
+		case rplEndOfWhois:
+			delete(pending, strings.ToLower(nick))
+		default:
+			// A nick that left before we asked only gets ERR_NOSUCHNICK,
+			// other errors like ERR_NOSUCHSERVER end the WHOIS too.
+			if isError(m.Command) {
+				delete(pending, strings.ToLower(nick))
+			}
+		}
+	}
+	return servers, malformed, nil
+}
+
+// names returns the nicks in a channel.
+func (c *conn) names(channel string) ([]string, error) {
+	if err := c.send("NAMES %s", channel); err != nil {
 		return nil, err
 	}
-	defer ircobj.Quit()
-	go ircobj.Loop()
-	ircobj.Join(IRCChannel)
-	ircobj.SendRawf("NAMES %s", IRCChannel)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	// NAMES END
-	ircobj.AddCallback("366", func(event *irc.Event) {
-		// THIS IS RACY UGH. This irc library doesn't preserve order of callbacks.
-		time.Sleep(100 * time.Millisecond)
-		wg.Done()
-	})
-
-	// NAMES
-	ircobj.AddCallback("353", func(event *irc.Event) {
-		if len(event.Arguments) < 4 {
-			return
+	var nicks []string
+	for {
+		m, err := c.read()
+		if err != nil {
+			return nil, err
 		}
-		words := strings.Split(event.Arguments[3], " ")
-		for _, nick := range words {
-			if strings.HasPrefix(nick, "E_") {
-				wg.Add(1)
-				ircobj.Whois(nick)
+		switch m.Command {
+		case rplNamReply:
+			for _, nick := range strings.Fields(m.param(len(m.Params) - 1)) {
+				nicks = append(nicks, strings.TrimLeft(nick, "@+%&~"))
 			}
+		case rplEndOfNames:
+			return nicks, nil
 		}
-	})
-
-	var serversLock sync.Mutex
-	var servers []string
-
-	// WHOIS realname
-	ircobj.AddCallback("311", func(event *irc.Event) {
-		detailsLine := strings.TrimSpace(event.Arguments[len(event.Arguments)-1])
-		serversLock.Lock()
-		servers = append(servers, detailsLine)
-		serversLock.Unlock()
-
-		wg.Done()
-	})
-	wg.Wait()
-	return servers, nil
+	}
+}
+
+// ctxErr prefers the context's error, since cancellation surfaces as a
+// closed connection.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Discoverer finds servers announced on IRC. Electrum servers have mostly
+// stopped announcing themselves there, so it's best combined with other
+// discoverers in an electrum.MultiDiscoverer.
+type Discoverer struct {
+	Options Options
+}
+
+func (d Discoverer) Discover(ctx context.Context) ([]*electrum.Peer, error) {
+	peers, malformed, err := FindElectrumServers(ctx, &d.Options)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range malformed {
+		log.Printf("ERR %s", entry)
+	}
+	return peers, nil
 }
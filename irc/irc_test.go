@@ -0,0 +1,206 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a minimal IRC server answering registration, NAMES and
+// WHOIS.
+type fakeServer struct {
+	l net.Listener
+	// names are the nicks in every channel.
+	names []string
+	// realnames are answered to WHOIS, nicks without one don't exist.
+	realnames map[string]string
+	// silent nicks never get a WHOIS reply.
+	silent map[string]bool
+	// replies are raw lines answered to WHOIS instead, %s is our nick.
+	replies map[string]string
+	// lines receives every line sent by clients.
+	lines chan string
+
+	connsLock sync.Mutex
+	conns     []net.Conn
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		l:         l,
+		realnames: make(map[string]string),
+		silent:    make(map[string]bool),
+		replies:   make(map[string]string),
+		lines:     make(chan string, 100),
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.connsLock.Lock()
+			s.conns = append(s.conns, c)
+			s.connsLock.Unlock()
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeServer) options() *Options {
+	return &Options{Server: s.l.Addr().String()}
+}
+
+// drop disconnects every client.
+func (s *fakeServer) drop() {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+// expect waits for a client line starting with prefix.
+func (s *fakeServer) expect(t *testing.T, prefix string) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-s.lines:
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", prefix)
+		}
+	}
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	send := func(format string, args ...interface{}) {
+		fmt.Fprintf(c, format+"\r\n", args...)
+	}
+	nick := "*"
+	r := bufio.NewScanner(c)
+	for r.Scan() {
+		line := r.Text()
+		s.lines <- line
+		m := parseMessage(line)
+		switch m.Command {
+		case "NICK":
+			nick = m.param(0)
+		case "USER":
+			send("PING :fake")
+			send(":fake 001 %s :Welcome", nick)
+		case "NAMES":
+			send(":fake 353 %s = %s :%s", nick, m.param(0), strings.Join(s.names, " "))
+			send(":fake 366 %s %s :End of /NAMES list.", nick, m.param(0))
+		case "WHOIS":
+			target := m.param(0)
+			if s.silent[target] {
+				continue
+			}
+			if reply, ok := s.replies[target]; ok {
+				send(reply, nick)
+				continue
+			}
+			if realname, ok := s.realnames[target]; ok {
+				send(":fake 311 %s %s user host * :%s", nick, target, realname)
+			} else {
+				send(":fake 401 %s %s :No such nick", nick, target)
+			}
+			send(":fake 318 %s %s :End of /WHOIS list.", nick, target)
+		case "QUIT":
+			return
+		}
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	m := parseMessage(":fake 311 me E_a user host * :host.example v1.4 s")
+	if m.Prefix != "fake" || m.Command != "311" || len(m.Params) != 6 || m.param(5) != "host.example v1.4 s" {
+		t.Errorf("message = %+v", m)
+	}
+	m = parseMessage("PING :token")
+	if m.Command != "PING" || m.param(0) != "token" {
+		t.Errorf("message = %+v", m)
+	}
+}
+
+func TestFindElectrumServers(t *testing.T) {
+	s := newFakeServer(t)
+	s.names = []string{"@E_a", "someone", "+E_b", "E_gone", "E_bad"}
+	s.realnames["E_a"] = "a.example v1.4 p10000 t s"
+	s.realnames["E_b"] = "b.example v1.2 s50012"
+	s.realnames["E_bad"] = "bad.example v1.4"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	servers, malformed, err := FindElectrumServers(ctx, s.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("servers = %+v", servers)
+	}
+	for _, p := range servers {
+		switch p.Host {
+		case "a.example":
+			if p.Pruning != 10000 || p.TCPPort != "50001" || p.SSLPort != "50002" {
+				t.Errorf("a = %+v", p)
+			}
+		case "b.example":
+			if p.TCPPort != "" || p.SSLPort != "50012" {
+				t.Errorf("b = %+v", p)
+			}
+		default:
+			t.Errorf("unexpected server %+v", p)
+		}
+	}
+	if len(malformed) != 1 || malformed[0].Nick != "E_bad" {
+		t.Errorf("malformed = %+v", malformed)
+	}
+}
+
+func TestFindElectrumServersDeadline(t *testing.T) {
+	s := newFakeServer(t)
+	s.names = []string{"E_a", "E_silent"}
+	s.realnames["E_a"] = "a.example t"
+	s.silent["E_silent"] = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, _, err := FindElectrumServers(ctx, s.options()); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestFindElectrumServersWhoisErrors(t *testing.T) {
+	s := newFakeServer(t)
+	s.names = []string{"E_a", "E_remote", "E_busy"}
+	s.realnames["E_a"] = "a.example t"
+	s.replies["E_remote"] = ":fake 402 %s E_remote :No such server"
+	s.replies["E_busy"] = ":fake 263 %s WHOIS :Please wait a while and try again."
+
+	// Without a deadline only the replies end the call.
+	servers, _, err := FindElectrumServers(context.Background(), s.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0].Host != "a.example" {
+		t.Errorf("servers = %+v", servers)
+	}
+}
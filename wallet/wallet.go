@@ -6,11 +6,14 @@ This is designed to make accepting and sending bitcoin really easy using Go.
 package wallet
 
 import (
+	"context"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"time"
 
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcutil"
-	"github.com/btcsuite/btcwallet/netparams"
 	"github.com/btcsuite/btcwallet/waddrmgr"
 	"github.com/btcsuite/btcwallet/wallet"
 	"github.com/btcsuite/btcwallet/walletdb"
@@ -23,13 +26,15 @@ import (
 var (
 	waddrmgrNamespaceKey = []byte("waddrmgrNamespace")
 	wtxmgrNamespaceKey   = []byte("wtxmgr")
-
-	bitcoinNetwork = &netparams.MainNetParams
 )
 
+// discoveryTimeout bounds finding servers when opening a wallet.
+const discoveryTimeout = 30 * time.Second
+
 type Wallet struct {
-	wallet *wallet.Wallet
-	node   *electrum.Node
+	wallet  *wallet.Wallet
+	node    *electrum.Node
+	network *electrum.Network
 }
 
 // Addresses returns all addresses generated in the current bitcoin wallet.
@@ -69,6 +74,12 @@ func (w *Wallet) SendBitcoin(amounts map[string]btcutil.Amount, minconf int) err
 		return err
 	}
 
+	for addr := range amounts {
+		if _, err := w.network.DecodeAddress(addr); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("creating tx")
 
 	// TODO: make this work
@@ -108,12 +119,12 @@ func (w *Wallet) SendBitcoin(amounts map[string]btcutil.Amount, minconf int) err
 
 	log.Printf("broadcasting")
 
-	resp, err := w.node.BlockchainTransactionBroadcast(rec.SerializedTx)
+	txid, err := w.node.BlockchainTransactionBroadcast(createdTx.MsgTx)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("RESP broadcast %#v", resp)
+	log.Printf("broadcast %s", txid)
 
 	return nil
 }
@@ -129,6 +140,14 @@ func stripManagedAddrs(mAddrs []waddrmgr.ManagedAddress) []btcutil.Address {
 // Create creates a wallet with the specified path, private key password and seed.
 // Seed can be created using: hdkeychain.GenerateSeed(hdkeychain.RecommendedSeedLen)
 func Create(path, privPass string, seed []byte) (*Wallet, error) {
+	return CreateNetwork(path, privPass, seed, electrum.MainNet, nil)
+}
+
+// CreateNetwork creates a wallet like Create for the given network, finding
+// servers with servers. A nil servers uses the network's bootstrap servers;
+// TestNet4, SigNet and RegTest have none, so they need e.g. an
+// electrum.StaticDiscoverer.
+func CreateNetwork(path, privPass string, seed []byte, network *electrum.Network, servers electrum.Discoverer) (*Wallet, error) {
 	db, err := walletdb.Create("bdb", path)
 	if err != nil {
 		return nil, err
@@ -138,13 +157,13 @@ func Create(path, privPass string, seed []byte) (*Wallet, error) {
 		return nil, err
 	}
 	manager, err := waddrmgr.Create(namespace, seed, nil,
-		[]byte(privPass), bitcoinNetwork.Params, nil)
+		[]byte(privPass), network.Params, nil)
 	if err != nil {
 		return nil, err
 	}
 	manager.Close()
 
-	return openWallet(db, privPass, seed)
+	return openWallet(db, privPass, seed, network, servers)
 }
 
 func returnBytes(bytes []byte) func() ([]byte, error) {
@@ -155,14 +174,20 @@ func returnBytes(bytes []byte) func() ([]byte, error) {
 
 // Load loads a wallet with the specified path, private key password and seed.
 func Load(path, privPass string, seed []byte) (*Wallet, error) {
+	return LoadNetwork(path, privPass, seed, electrum.MainNet, nil)
+}
+
+// LoadNetwork loads a wallet like Load for the given network, finding servers
+// like CreateNetwork.
+func LoadNetwork(path, privPass string, seed []byte, network *electrum.Network, servers electrum.Discoverer) (*Wallet, error) {
 	db, err := walletdb.Open("bdb", path)
 	if err != nil {
 		return nil, err
 	}
-	return openWallet(db, privPass, seed)
+	return openWallet(db, privPass, seed, network, servers)
 }
 
-func openWallet(db walletdb.DB, privPass string, seed []byte) (*Wallet, error) {
+func openWallet(db walletdb.DB, privPass string, seed []byte, network *electrum.Network, servers electrum.Discoverer) (*Wallet, error) {
 	addrMgrNS, err := db.Namespace(waddrmgrNamespaceKey)
 	if err != nil {
 		return nil, err
@@ -176,20 +201,21 @@ func openWallet(db walletdb.DB, privPass string, seed []byte) (*Wallet, error) {
 		ObtainSeed:        returnBytes(seed),
 		ObtainPrivatePass: returnBytes([]byte(privPass)),
 	}
-	backWallet, err := wallet.Open(nil, bitcoinNetwork.Params, db, addrMgrNS, txMgrNS, cbs)
+	backWallet, err := wallet.Open(nil, network.Params, db, addrMgrNS, txMgrNS, cbs)
 	if err != nil {
 		return nil, err
 	}
 
 	// TODO: use more than 1 node
-	node := electrum.NewNode()
-	if err := node.ConnectTCP("btc.mustyoshi.com:50001"); err != nil {
+	node, err := connectNode(network, servers)
+	if err != nil {
 		return nil, err
 	}
 
 	w := &Wallet{
-		wallet: backWallet,
-		node:   node,
+		wallet:  backWallet,
+		node:    node,
+		network: network,
 	}
 
 	addrs, err := w.Addresses()
@@ -205,36 +231,73 @@ func openWallet(db walletdb.DB, privPass string, seed []byte) (*Wallet, error) {
 	return w, nil
 }
 
+// connectNode connects to the best ranked server found by servers, or the
+// network's bootstrap servers if nil, that accepts the connection.
+func connectNode(network *electrum.Network, servers electrum.Discoverer) (*electrum.Node, error) {
+	if servers == nil {
+		servers = electrum.BootstrapDiscoverer{Network: network}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	peers, err := electrum.MultiDiscoverer{servers}.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lastErr := fmt.Errorf("no servers known for %s", network.Name())
+	for _, peer := range peers {
+		node := electrum.NewNode()
+		node.Network = network
+		if err := node.ConnectPeer(peer, nil); err != nil {
+			lastErr = err
+			continue
+		}
+		return node, nil
+	}
+	return nil, lastErr
+}
+
 func (w *Wallet) watchAddress(addr string) error {
-	c, err := w.node.BlockchainAddressSubscribe(addr)
+	updates, err := w.node.BlockchainAddressSubscribeHistory(addr)
 	if err != nil {
 		return err
 	}
-	// TODO(d4l3k) handle history
-	// w.node.BlockchainAddressGetHistory
-	go w.handleTransactions(c)
+	go w.handleHistory(updates)
 	return nil
 }
 
-func (w *Wallet) handleTransactions(c <-chan string) {
-	var err error
-	for txid := range c {
-		var tx string
-		if tx, err = w.node.BlockchainTransactionGet(txid); err != nil {
-			break
+// handleHistory inserts the transactions of an address's history into the
+// wallet as they appear.
+func (w *Wallet) handleHistory(updates <-chan *electrum.HistoryUpdate) {
+	inserted := make(map[chainhash.Hash]bool)
+	for update := range updates {
+		if update.Err != nil {
+			log.Println(update.Err)
+			continue
 		}
-		if err = w.insertTx(tx); err != nil {
-			break
+		for _, item := range update.History {
+			if inserted[item.TxID] {
+				continue
+			}
+			tx, err := w.node.BlockchainTransactionGetConfirmed(item.TxID.String(), item.Height)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if err := w.insertTx(tx); err != nil {
+				log.Println(err)
+				continue
+			}
+			inserted[item.TxID] = true
 		}
 	}
-	if err != nil {
-		log.Println(err)
-		// TODO(d4l3k): Better error handling.
-	}
 }
 
-func (w *Wallet) insertTx(tx string) error {
-	rec, err := wtxmgr.NewTxRecord([]byte(tx), time.Now())
+func (w *Wallet) insertTx(txHex string) error {
+	tx, err := hex.DecodeString(txHex)
+	if err != nil {
+		return err
+	}
+	rec, err := wtxmgr.NewTxRecord(tx, time.Now())
 	if err != nil {
 		return err
 	}